@@ -39,3 +39,32 @@ func CheckAddr(ip net.IP) error {
 	}
 	return nil
 }
+
+// Raise error if network address is in yggdrasil addreses range.
+// Non-ip addresses (like unix socket paths) are always acceptable.
+func CheckNetAddr(a net.Addr) error {
+	if a == nil {
+		return nil
+	}
+	var ip net.IP
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	case *net.UnixAddr:
+		return nil
+	default:
+		host, _, err := net.SplitHostPort(a.String())
+		if err != nil {
+			return nil
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return nil
+	}
+	return CheckAddr(ip)
+}
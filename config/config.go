@@ -0,0 +1,209 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+// Package config loads yggdrasil-go node config files
+// (HJSON or JSON) and builds ConnManager from them.
+//
+//	cfg, err := config.Load("/etc/yggdrasil.conf")
+//	if err != nil {
+//		panic(err)
+//	}
+//	node, err := cfg.Build(ctx)
+//	if err != nil {
+//		panic(err)
+//	}
+//	defer node.Manager.Close()
+//	for _, peer := range node.Peers {
+//		node.Manager.ConnectCtx(ctx, peer)
+//	}
+//
+// Only fields related to peering are used, others are ignored.
+package config
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"github.com/Yggdrasil-Unofficial/ytl"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"github.com/hjson/hjson-go/v4"
+	"net/url"
+	"os"
+	"sort"
+)
+
+// Fields of yggdrasil-go config used by ytl
+type NodeConfig struct {
+	// Hex encoded ed25519 private key.
+	// If empty, random key is used.
+	PrivateKey string
+	// Uris of peers to connect to
+	Peers []string
+	// Uris of peers to connect to via specific network interface,
+	// keyed by interface name
+	InterfacePeers map[string][]string
+	// Uris to listen for incoming connections on
+	Listen []string
+	// Hex encoded public keys of nodes allowed to connect.
+	// If empty, any node is allowed.
+	AllowedPublicKeys []string
+}
+
+// Parses config in HJSON or JSON format
+func Parse(data []byte) (*NodeConfig, error) {
+	cfg := &NodeConfig{}
+	if err := hjson.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Reads and parses config file
+func Load(path string) (*NodeConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Returns decoded private key or nil if it is not set
+func (c *NodeConfig) Key() (ed25519.PrivateKey, error) {
+	if c.PrivateKey == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(c.PrivateKey)
+	if err != nil {
+		return nil, InvalidFieldError{Field: "PrivateKey", Text: err.Error()}
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, InvalidFieldError{Field: "PrivateKey", Text: "wrong key length"}
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// Returns decoded AllowedPublicKeys or nil if the list is empty
+func (c *NodeConfig) AllowList() (*static.AllowList, error) {
+	if len(c.AllowedPublicKeys) == 0 {
+		return nil, nil
+	}
+	allow := make(static.AllowList, 0, len(c.AllowedPublicKeys))
+	for _, value := range c.AllowedPublicKeys {
+		key, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, InvalidFieldError{Field: "AllowedPublicKeys", Text: err.Error()}
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, InvalidFieldError{Field: "AllowedPublicKeys", Text: "wrong key length"}
+		}
+		allow = append(allow, ed25519.PublicKey(key))
+	}
+	return &allow, nil
+}
+
+// Returns canonical uris of Peers followed by uris of InterfacePeers.
+//
+// Uris of InterfacePeers get "bind" param with interface name.
+// Interfaces are ordered by name.
+func (c *NodeConfig) PeerURIs() ([]url.URL, error) {
+	peers, err := parseUris("Peers", c.Peers)
+	if err != nil {
+		return nil, err
+	}
+	interfaces := make([]string, 0, len(c.InterfacePeers))
+	for name := range c.InterfacePeers {
+		interfaces = append(interfaces, name)
+	}
+	sort.Strings(interfaces)
+	for _, name := range interfaces {
+		uris, err := parseUris("InterfacePeers", c.InterfacePeers[name])
+		if err != nil {
+			return nil, err
+		}
+		for _, uri := range uris {
+			query := uri.Query()
+			query.Set("bind", name)
+			uri.RawQuery = query.Encode()
+			peers = append(peers, uri)
+		}
+	}
+	return peers, nil
+}
+
+// Returns canonical Listen uris
+func (c *NodeConfig) ListenURIs() ([]url.URL, error) {
+	return parseUris("Listen", c.Listen)
+}
+
+func parseUris(field string, values []string) ([]url.URL, error) {
+	uris := make([]url.URL, 0, len(values))
+	for _, value := range values {
+		peer, err := static.ParsePeerURI(value)
+		if err != nil {
+			return nil, InvalidFieldError{Field: field, Text: err.Error()}
+		}
+		uris = append(uris, peer.URL())
+	}
+	return uris, nil
+}
+
+// Node built from config
+type Node struct {
+	Manager *ytl.ConnManager
+	// Listeners opened for Listen uris
+	Listeners []ytl.YggListener
+	// Peer uris ready to be passed to Manager.ConnectCtx
+	Peers []url.URL
+}
+
+// Creates ConnManager with key from config and opens listeners.
+//
+// Like yggdrasil-go does, AllowedPublicKeys is applied
+// to incoming connections only.
+// Options are applied after ones derived from config.
+//
+// If any listener can not be opened, manager is closed
+// and error is returned.
+func (c *NodeConfig) Build(ctx context.Context, options ...ytl.Option) (*Node, error) {
+	key, err := c.Key()
+	if err != nil {
+		return nil, err
+	}
+	allowList, err := c.AllowList()
+	if err != nil {
+		return nil, err
+	}
+	listen, err := c.ListenURIs()
+	if err != nil {
+		return nil, err
+	}
+	peers, err := c.PeerURIs()
+	if err != nil {
+		return nil, err
+	}
+	manager := ytl.New(ctx, append([]ytl.Option{ytl.WithKey(key)}, options...)...)
+	node := &Node{Manager: manager, Peers: peers}
+	for _, uri := range listen {
+		listener, err := manager.Listen(uri)
+		if err != nil {
+			manager.Close()
+			return nil, err
+		}
+		listener.SetAllowList(allowList)
+		node.Listeners = append(node.Listeners, listener)
+	}
+	return node, nil
+}
@@ -0,0 +1,200 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package config
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"github.com/Yggdrasil-Unofficial/ytl"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+{
+  # Comments are allowed in HJSON
+  Peers: [
+    tcp://a.example:1
+    "tls://B.example:2?priority=1&maxbackoff="
+  ]
+  InterfacePeers: {
+    eth1: ["tcp://c.example:3"]
+    eth0: ["tcp://d.example:4"]
+  }
+  Listen: []
+  AllowedPublicKeys: []
+  IfName: auto
+  NodeInfoPrivacy: false
+}
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cfg.Key()
+	if err != nil || key != nil {
+		t.Fatalf("Empty key must be nil: %v %v", key, err)
+	}
+	allow, err := cfg.AllowList()
+	if err != nil || allow != nil {
+		t.Fatalf("Empty allow list must be nil: %v %v", allow, err)
+	}
+	peers, err := cfg.PeerURIs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"tcp://a.example:1",
+		"tls://b.example:2?priority=1",
+		"tcp://d.example:4?bind=eth0",
+		"tcp://c.example:3?bind=eth1",
+	}
+	if len(peers) != len(expected) {
+		t.Fatalf("Unexpected peers: %v", peers)
+	}
+	for i, peer := range peers {
+		if peer.String() != expected[i] {
+			t.Fatalf("Expected %s, got %s", expected[i], peer.String())
+		}
+	}
+}
+
+func TestParseJson(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	pub, _, _ := ed25519.GenerateKey(nil)
+	data := `{"PrivateKey": "` + hex.EncodeToString(priv) + `",` +
+		`"AllowedPublicKeys": ["` + hex.EncodeToString(pub) + `"]}`
+	cfg, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cfg.Key()
+	if err != nil || !key.Equal(priv) {
+		t.Fatalf("Unexpected key: %v", err)
+	}
+	allow, err := cfg.AllowList()
+	if err != nil || allow == nil || !allow.IsAllow(pub) || len(*allow) != 1 {
+		t.Fatalf("Unexpected allow list: %v", err)
+	}
+}
+
+func TestInvalidFields(t *testing.T) {
+	cases := map[string]NodeConfig{
+		"PrivateKey":        {PrivateKey: "zz"},
+		"AllowedPublicKeys": {AllowedPublicKeys: []string{"0011"}},
+		"Peers":             {Peers: []string{"tcp://a.example:1?key=00"}},
+		"InterfacePeers":    {InterfacePeers: map[string][]string{"eth0": {"%"}}},
+		"Listen":            {Listen: []string{"tcp://"}},
+	}
+	for field, cfg := range cases {
+		_, err := cfg.Build(context.Background())
+		if e, ok := err.(InvalidFieldError); !ok || e.Field != field {
+			t.Fatalf("Expected error of %s field, got %v", field, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yggdrasil.conf")
+	if err := os.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Peers) != 2 || len(cfg.InterfacePeers) != 2 {
+		t.Fatalf("Unexpected config: %v", cfg)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.conf")); err == nil {
+		t.Fatalf("Missing file must be error")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	_, allowedKey, _ := ed25519.GenerateKey(nil)
+	cfg := NodeConfig{
+		PrivateKey:        hex.EncodeToString(priv),
+		Listen:            []string{"tcp://127.0.0.1:0"},
+		AllowedPublicKeys: []string{hex.EncodeToString(allowedKey.Public().(ed25519.PublicKey))},
+		Peers:             []string{"tcp://a.example:1"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node, err := cfg.Build(
+		ctx,
+		ytl.WithSendHandshake(true),
+		ytl.WithHandshakeTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Manager.Close()
+	if len(node.Listeners) != 1 || len(node.Peers) != 1 {
+		t.Fatalf("Unexpected node: %v", node)
+	}
+	listener := node.Listeners[0]
+	addr := url.URL{Scheme: "tcp", Host: listener.Addr().String()}
+
+	connect := func(key ed25519.PrivateKey) error {
+		errs := make(chan error, 1)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			conn, err := listener.Accept()
+			if err == nil {
+				_, err = conn.GetPublicKey()
+				defer conn.Close()
+			}
+			errs <- err
+			<-done
+		}()
+		client := ytl.New(ctx, ytl.WithKey(key), ytl.WithSendHandshake(true))
+		defer client.Close()
+		conn, err := client.ConnectCtx(ctx, addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := <-errs; err != nil {
+			return err
+		}
+		pkey, err := conn.GetPublicKey()
+		if err != nil {
+			return err
+		}
+		if !pkey.Equal(priv.Public()) {
+			t.Fatalf("Node must use key from config")
+		}
+		return nil
+	}
+
+	if err := connect(allowedKey); err != nil {
+		t.Fatalf("Allowed node must be accepted: %v", err)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	if err := connect(otherKey); err == nil {
+		t.Fatalf("Not allowed node must be rejected")
+	}
+}
@@ -31,6 +31,16 @@
 //			nil,
 //		)
 //
+// Manager may also be created with functional options,
+// that allow to pass only needed params.
+//
+//		manager := ytl.New(
+//			context.Background(),
+//			ytl.WithKey(priv), // Your ygg private key
+//			ytl.WithDeduplication(ytl.NewDeduplicationManager(true, nil)),
+//			ytl.WithHandshakeTimeout(30*time.Second),
+//		)
+//
 // If you want to use a specific private and public key pair,
 // you need to pass your private key to the ConnManager constructor.
 // If key is not passed,
@@ -78,9 +88,9 @@
 //			},
 //		)
 //
-// If you want to proxify connections to certain hosts via socks proxy,
-// you need to pass the ProxyManager object with the appropriate rules
-// to the ConnManager constructor.
+// If you want to proxify connections to certain hosts via socks
+// or HTTP CONNECT proxy, you need to pass the ProxyManager object
+// with the appropriate rules to the ConnManager constructor.
 //
 // ( See more info in ProxyManager type documentation. )
 //
@@ -108,6 +118,27 @@
 //			nil,
 //		)
 //
+// Mapping may list several Candidates instead of single Proxy.
+// ConnManager probes them in background and uses the first healthy one,
+// so dials do not wait for timeout of proxy which is down.
+//
+//		HostRegexp: *regexp.MustCompile(`\.onion$`),
+//		Candidates: []*url.URL{torProxy, torBackupProxy},
+//
+// Mappings may also match destination networks, ports, schemes
+// and pinned keys, and may force direct connection or block destination.
+//
+//		{
+//			Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+//			Action:   ytl.PROXY_ACTION_DIRECT,
+//		},
+//
+// Networks match only ip literal hosts unless ResolveHost is set,
+// because resolving host names locally leaks dns queries past proxies.
+//
+// Set Isolation of mapping to PROXY_ISOLATION_PEER or PROXY_ISOLATION_CONN
+// to give each peer or each connection its own Tor circuit.
+//
 // After you have created the ConnManager object,
 // you can use it to open outgoing connections with the Connect method
 // ( ConnectCtx and ConnectTimeout methods are also available ).
@@ -133,14 +164,37 @@
 //			}
 //		}
 //
+// All listeners and connections opened by ConnManager are closed
+// when its context is canceled or Close method is called.
+// Shutdown method also allows connections to be closed by their owners
+// during grace period.
+//
+//		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//		defer cancel()
+//		err := manager.Shutdown(ctx)
+//
+// If you want to keep connections with some peers opened,
+// you may use PeerSupervisor.
+// It reconnects to each added peer after connection is closed.
+//
+//		supervisor := ytl.NewPeerSupervisor(manager)
+//		addr, _ := url.Parse("tls://1.2.3.4:1337?maxbackoff=1m")
+//		if _, err := supervisor.AddPeer(*addr); err != nil {
+//			// Handle invalid uri
+//		}
+//		for conn := range supervisor.Conns() {
+//			// Handle connection
+//		}
+//
 package ytl
 
 import (
 	"context"
 	"crypto/ed25519"
-	"encoding/hex"
+	"errors"
 	"github.com/Yggdrasil-Unofficial/ytl/static"
-	"github.com/Yggdrasil-Unofficial/ytl/transports"
+	"log/slog"
+	"net"
 	"net/url"
 	"time"
 )
@@ -178,12 +232,28 @@ type ConnManager struct {
 	proxyManager ProxyManager
 	allowList    *static.AllowList
 	ctx          context.Context
+	cancel       context.CancelFunc
 	dm           *DeduplicationManager
+	sendMeta     bool
+	versions     []static.ProtoVersion
+	registry     *connRegistry
+	observer     Observer
+	logger       *slog.Logger
+	resolver     static.Resolver
+	bind         static.BindOptions
+	// Max time to wait for handshake pkg
+	handshakeTimeout time.Duration
+	// Limits of pending handshakes for listeners
+	pendingLimit      int
+	pendingLimitPerIp int
 }
 
 // Create new ConnManager with custom transports list.
 //
 // Key can be nill.
+//
+// It is a shortcut for New with WithKey, WithProxyManager,
+// WithDeduplication, WithAllowList and WithTransports options.
 func NewConnManagerWithTransports(
 	ctx context.Context,
 	key ed25519.PrivateKey,
@@ -192,12 +262,14 @@ func NewConnManagerWithTransports(
 	allowList *static.AllowList,
 	transports []static.Transport,
 ) *ConnManager {
-	transports_map := transportsListToMap(transports)
-	if proxy == nil {
-		p := NewProxyManager(nil, nil)
-		proxy = &p
-	}
-	return &ConnManager{transports_map, key, *proxy, allowList, ctx, dm}
+	return New(
+		ctx,
+		WithKey(key),
+		WithProxyManager(proxy),
+		WithDeduplication(dm),
+		WithAllowList(allowList),
+		WithTransports(transports),
+	)
 }
 
 // Create new ConnManager with default transports list.
@@ -210,16 +282,146 @@ func NewConnManager(
 	dm *DeduplicationManager,
 	allowList *static.AllowList,
 ) *ConnManager {
-	return NewConnManagerWithTransports(
+	return New(
 		ctx,
-		key,
-		proxy,
-		dm,
-		allowList,
-		transports.DEFAULT_TRANSPORTS(),
+		WithKey(key),
+		WithProxyManager(proxy),
+		WithDeduplication(dm),
+		WithAllowList(allowList),
 	)
 }
 
+// Enables or disables sending own handshake pkg
+// (header, protocol version and public key derived from manager key)
+// by connections returned from Connect and Listen methods.
+//
+// If it is enabled, these connections are ready-to-use yggdrasil links
+// and received handshake pkg is not passed to reader.
+// Otherwise, caller must write own handshake pkg by itself.
+//
+// It is disabled by default.
+// It should be called before opening any connection.
+func (c *ConnManager) SetSendHandshake(enabled bool) {
+	c.sendMeta = enabled
+}
+
+// Sets observer that receives events of connections lifecycle.
+// Nil disables events.
+//
+// It should be called before opening any connection.
+func (c *ConnManager) SetObserver(observer Observer) {
+	c.observer = observer
+}
+
+// Sets max time to wait for handshake pkg of other node
+// by connections returned from Connect and Listen methods.
+// Zero means DEFAULT_HANDSHAKE_TIMEOUT.
+//
+// It should be called before opening any connection.
+func (c *ConnManager) SetHandshakeTimeout(timeout time.Duration) {
+	c.handshakeTimeout = timeout
+}
+
+// Sets default limits of connections waiting for handshake pkg
+// for listeners returned from Listen method.
+// Transport handshakes are not limited by them,
+// see YggListener.SetPendingHandshakesLimit.
+//
+// It should be called before opening any listener.
+func (c *ConnManager) SetPendingHandshakesLimit(total, perIp int) {
+	c.pendingLimit = total
+	c.pendingLimitPerIp = perIp
+}
+
+// Sets logger for manager and connections opened by it.
+// It is also passed to transports and dialers.
+// Nil disables logging.
+//
+// It should be called before opening any connection.
+func (c *ConnManager) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// Sets resolver passed to transports and dialers
+// to look up peer host names.
+// Nil means system resolver.
+//
+// See dialers.CachingResolver to avoid resolving hosts on each reconnect.
+//
+// It should be called before opening any connection.
+func (c *ConnManager) SetResolver(resolver static.Resolver) {
+	c.resolver = resolver
+}
+
+// Sets default source address, interface and firewall mark
+// of outgoing connections. They are passed to transports
+// and dialers, so "bind", "source" and "mark"
+// uri params of peer override them.
+//
+// It should be called before opening any connection.
+func (c *ConnManager) SetBindOptions(options static.BindOptions) {
+	c.bind = options
+}
+
+// Connects with options if transport accepts them.
+// Otherwise only the first proxy of chain can be used,
+// so longer chains cause static.ProxyChainUnsupportedError.
+func connect(
+	ctx context.Context,
+	transport static.Transport,
+	uri url.URL,
+	key ed25519.PrivateKey,
+	options static.DialOptions,
+) (static.ConnResult, error) {
+	if t, ok := transport.(static.DialOptionsTransport); ok {
+		return t.ConnectWithOptions(ctx, uri, key, options)
+	}
+	var proxy *url.URL = nil
+	switch len(options.ProxyChain) {
+	case 0:
+	case 1:
+		proxy = options.ProxyChain[0]
+	default:
+		return static.ConnResult{}, static.ProxyChainUnsupportedError{Transport: uri.Scheme}
+	}
+	return transport.Connect(ctx, uri, proxy, key)
+}
+
+func (c *ConnManager) log() *slog.Logger {
+	return static.LoggerOrNop(c.logger)
+}
+
+// Sets protocol versions accepted from other nodes.
+// If versions is empty, all SupportedProtoVersions are accepted.
+//
+// Outgoing connections that send own handshake pkg
+// use the newest of these versions.
+// Incoming ones reply with the same version as connecting node uses,
+// so one manager can serve nodes with different versions.
+// Use YggConn.GetVer to get version used by connection.
+//
+// It should be called before opening any connection.
+func (c *ConnManager) SetProtoVersions(versions []static.ProtoVersion) {
+	c.versions = versions
+}
+
+// Returns handshake config for connection with uri.
+//
+// Password and priority are taken from "password" and "priority" uri params.
+// Key is set only if sending own handshake pkg is enabled.
+func (c *ConnManager) handshakeConfig(key ed25519.PrivateKey, uri static.PeerURI) *HandshakeConfig {
+	config := &HandshakeConfig{
+		Versions: c.versions,
+		Password: []byte(uri.Password),
+		Priority: uri.Priority,
+		Timeout:  c.handshakeTimeout,
+	}
+	if c.sendMeta {
+		config.Key = key
+	}
+	return config
+}
+
 // Selects the appropriate transport implementation
 // based on the uri scheme and opens the connection.
 //
@@ -233,40 +435,118 @@ func NewConnManager(
 // If ConnManager was constructed with non nil DeduplicationManager,
 // it will be used to close duplicate connections on early stage.
 //
+// If uri has "password" param, connections with nodes
+// that do not sign TLV handshake pkg with the same password are rejected.
+//
+// If uri has "proxy" param, it is used instead of ProxyManager,
+// but destinations blocked by ProxyManager rules are still rejected.
+// Uri is validated with static.PeerURIFromURL,
+// so malformed params like "key" cause InvalidUriError.
+//
 // It also accepts a context that allows you to
 // cancel the process ahead of time.
 func (c *ConnManager) ConnectCtx(ctx context.Context, uri url.URL) (*YggConn, error) {
+	if c.registry.isClosed() {
+		return nil, static.ManagerClosedError{}
+	}
+	peer, err := static.PeerURIFromURL(uri)
+	if err != nil {
+		return nil, err
+	}
 	var allowList *static.AllowList = nil
 	if c.allowList != nil {
 		allow := make(static.AllowList, len(*c.allowList))
 		copy(allow, *c.allowList)
 		allowList = &allow
 	}
-	if pubkeys, ok := uri.Query()["key"]; ok && len(pubkeys) > 0 {
-		allow := make(static.AllowList, 0)
-		for _, pubkey := range pubkeys {
-			if key, err := hex.DecodeString(pubkey); err == nil {
-				allow = append(allow, key)
-			}
-		}
+	if len(peer.Keys) > 0 {
+		allow := static.AllowList(peer.Keys)
 		allowList = &allow
 	}
 	if transport, ok := c.transports[uri.Scheme]; ok {
-		conn, err := transport.Connect(
+		started := time.Now()
+		key := KeyFromOptionalKey(c.key)
+		// Rules are checked even with "proxy" param to keep blocked hosts blocked
+		chain, err := c.proxyManager.GetChainCtx(ctx, uri, c.resolver)
+		if err != nil {
+			c.log().Info("Can not connect", "uri", static.RedactedURI(uri), "error", err)
+			if c.observer != nil {
+				c.observer.OnDial(static.RedactURL(uri), err)
+			}
+			return nil, err
+		}
+		if peer.Proxy != nil {
+			chain = []*url.URL{peer.Proxy}
+		} else if peer.Direct {
+			chain = nil
+		}
+		var proxy *url.URL = nil
+		if len(chain) > 0 {
+			proxy = chain[0]
+		}
+		logger := c.log().With("scheme", uri.Scheme, "uri", static.RedactedURI(uri))
+		if proxy != nil {
+			logger = logger.With("proxy", static.RedactedURI(*proxy))
+		}
+		logger.Debug("Connecting")
+		conn, err := connect(
 			ctx,
+			transport,
 			uri,
-			c.proxyManager.Get(uri),
-			KeyFromOptionalKey(c.key),
+			key,
+			static.DialOptions{
+				Logger:     logger,
+				Resolver:   c.resolver,
+				Bind:       c.bind,
+				ProxyChain: chain,
+			},
 		)
+		if c.observer != nil {
+			c.observer.OnDial(static.RedactURL(uri), err)
+		}
+		if err != nil {
+			logger.Info("Can not connect", "error", err)
+			if conn.Conn != nil {
+				conn.Conn.Close()
+			}
+			return nil, err
+		}
+		state := newConnState(
+			uri,
+			proxy,
+			static.DIRECTION_OUTBOUND,
+			conn.SecurityLevel,
+			c.observer,
+			c.logger,
+		)
+		state.started = started
 		if allowList != nil {
 			if !allowList.IsAllow(conn.Pkey) || conn.Pkey == nil {
 				conn.Conn.Close()
-				return nil, static.IvalidPeerPublicKey{
+				err = static.IvalidPeerPublicKey{
 					Text: "Key received from the peer is not in the allow list",
 				}
+				logger.Info("Connection is rejected", "error", err)
+				if c.observer != nil {
+					c.observer.OnReject(state.snapshot(), err)
+					c.observer.OnClose(state.snapshot())
+				}
+				return nil, err
 			}
 		}
-		return ConnToYggConn(conn.Conn, conn.Pkey, allowList, conn.SecurityLevel, c.dm), err
+		ygg := newYggConn(
+			conn.Conn,
+			conn.Pkey,
+			allowList,
+			c.dm,
+			c.handshakeConfig(key, peer),
+			state,
+		)
+		if !c.registry.add(ygg) {
+			ygg.Close()
+			return nil, static.ManagerClosedError{}
+		}
+		return ygg, nil
 	}
 	return nil, static.UnknownSchemeError{Scheme: uri.Scheme}
 }
@@ -332,23 +612,132 @@ func (c *ConnManager) ConnectTimeout(uri url.URL, timeout time.Duration) (*YggCo
 	}
 }
 
+// Returns snapshots of all active connections
+// opened by Connect methods or accepted by listeners
+// returned from Listen method.
+//
+// Closed connections are removed from the list automatically.
+func (c *ConnManager) Connections() []ConnSnapshot {
+	return c.registry.snapshots()
+}
+
+// Returns total traffic of connections opened by Connect methods
+// or accepted by listeners returned from Listen method,
+// closed ones included.
+//
+// It never decreases, so it is suitable for counters.
+func (c *ConnManager) Traffic() TrafficStats {
+	return c.registry.traffic()
+}
+
 // Selects the appropriate transport implementation
 // based on the uri scheme and create listener object
 // that accpet incoming connections.
+//
+// If uri has "password" param, connections with nodes
+// that do not sign TLV handshake pkg with the same password are rejected.
 func (c *ConnManager) Listen(uri url.URL) (ygg YggListener, err error) {
+	if c.registry.isClosed() {
+		err = static.ManagerClosedError{}
+		return
+	}
+	peer, err := static.PeerURIFromURL(uri)
+	if err != nil {
+		return
+	}
 	if transport, ok := c.transports[uri.Scheme]; ok {
-		listener, e := transport.Listen(c.ctx, uri, KeyFromOptionalKey(c.key))
+		key := KeyFromOptionalKey(c.key)
+		logger := c.log().With("scheme", uri.Scheme, "uri", static.RedactedURI(uri))
+		listener, e := transport.Listen(c.ctx, uri, key)
 		err = e
 		if err != nil {
+			logger.Warn("Can not listen", "error", err)
+			return
+		}
+		if addr := listener.Addr(); addr != nil {
+			logger = logger.With("address", addr.String())
+		}
+		logger.Info("Listening")
+		id, ok := c.registry.addListener(listener)
+		if !ok {
+			listener.Close()
+			err = static.ManagerClosedError{}
 			return
 		}
-		ygg = YggListener{listener, c.dm, c.allowList}
+		handshake := c.handshakeConfig(key, peer)
+		handshake.Responder = true
+		ygg = YggListener{
+			inner_listener: listener,
+			dm:             c.dm,
+			allowList:      c.allowList,
+			handshake:      handshake,
+			uri:            uri,
+			registry:       c.registry,
+			listenerId:     id,
+			observer:       c.observer,
+			logger:         c.logger,
+		}
+		ygg.SetPendingHandshakesLimit(c.pendingLimit, c.pendingLimitPerIp)
 		return
 	}
 	err = static.UnknownSchemeError{Scheme: uri.Scheme}
 	return
 }
 
+// Closes all listeners and connections immediately.
+//
+// Manager can not be used after that.
+// It is called automatically when manager context is canceled.
+func (c *ConnManager) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return c.Shutdown(ctx)
+}
+
+// Closes all listeners,
+// then waits until all connections are closed or ctx is done
+// and closes the remaining ones.
+//
+// Returns errors raised while closing joined together.
+// Manager can not be used after that.
+func (c *ConnManager) Shutdown(ctx context.Context) error {
+	listeners, conns := c.registry.close()
+	if listeners != nil || conns != nil {
+		c.log().Info(
+			"Shutting down",
+			"listeners", len(listeners),
+			"connections", len(conns),
+		)
+	}
+	// Stops connecting and calls Close, that does nothing now
+	c.cancel()
+	errs := make([]error, 0)
+	for _, listener := range listeners {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+drain:
+	for _, conn := range conns {
+		select {
+		case <-conn.Closed():
+		case <-ctx.Done():
+			break drain
+		}
+	}
+	for _, conn := range conns {
+		select {
+		case <-conn.Closed():
+			continue
+		default:
+		}
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 var generateKey func() (
 	ed25519.PublicKey,
 	ed25519.PrivateKey,
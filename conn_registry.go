@@ -0,0 +1,309 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ytl
+
+import (
+	"crypto/ed25519"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"log/slog"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot of active connection state
+type ConnSnapshot struct {
+	// Public key of connected node.
+	// Nil if handshake pkg is not received yet.
+	PublicKey ed25519.PublicKey
+	// Protocol version of connection.
+	// Nil if handshake pkg is not received yet.
+	Version *static.ProtoVersion
+	// Transport scheme
+	Scheme string
+	// Uri passed to Connect or Listen.
+	// Secrets are masked (see static.RedactURL).
+	Uri url.URL
+	// Proxy used for outgoing connection or nil.
+	// Password is masked.
+	Proxy *url.URL
+	// One of static.DIRECTION_* constants
+	Direction     uint
+	SecurityLevel uint
+	ConnectedAt   time.Time
+	// Time from start of connecting (or accepting)
+	// to parsed handshake pkg.
+	// Zero if handshake pkg is not received yet.
+	HandshakeLatency time.Duration
+	// Count of bytes received and sent, handshake pkgs included
+	BytesIn  uint64
+	BytesOut uint64
+	// Count of non-empty Read and Write calls of YggConn
+	PacketsIn  uint64
+	PacketsOut uint64
+}
+
+// State shared by YggConn and all its copies
+type connState struct {
+	mutex    sync.Mutex
+	info     ConnSnapshot
+	closefns []func()
+	closed   bool
+	observer Observer
+	logger   *slog.Logger
+	started  time.Time
+	// Called once when handshake is finished or failed
+	handshakeDone func()
+	bytesIn       atomic.Uint64
+	bytesOut      atomic.Uint64
+	// Are counted by YggConn
+	packetsIn  atomic.Uint64
+	packetsOut atomic.Uint64
+}
+
+func newConnState(
+	uri url.URL,
+	proxy *url.URL,
+	direction uint,
+	secureTranport uint,
+	observer Observer,
+	logger *slog.Logger,
+) *connState {
+	state := &connState{observer: observer, logger: static.LoggerOrNop(logger)}
+	state.info.Scheme = uri.Scheme
+	state.info.Uri = static.RedactURL(uri)
+	if proxy != nil {
+		redacted := static.RedactURL(*proxy)
+		state.info.Proxy = &redacted
+	}
+	state.info.Direction = direction
+	state.info.SecurityLevel = secureTranport
+	state.info.ConnectedAt = time.Now()
+	state.started = state.info.ConnectedAt
+	return state
+}
+
+// Returns logger with connection info attributes
+func (s *connState) log() *slog.Logger {
+	info := s.snapshot()
+	attrs := []any{
+		"scheme", info.Scheme,
+		"uri", info.Uri.String(),
+		"direction", info.Direction,
+		"security_level", info.SecurityLevel,
+	}
+	if info.Proxy != nil {
+		attrs = append(attrs, "proxy", info.Proxy.String())
+	}
+	if info.PublicKey != nil {
+		attrs = append(attrs, static.KeyAttr("peer_key", info.PublicKey))
+	}
+	return s.logger.With(attrs...)
+}
+
+func (s *connState) finishHandshake() {
+	if s.handshakeDone != nil {
+		s.handshakeDone()
+	}
+}
+
+// Sets info received from handshake pkg
+func (s *connState) setPeer(version *static.ProtoVersion, pkey ed25519.PublicKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.info.Version = version
+	s.info.PublicKey = pkey
+	s.info.HandshakeLatency = time.Since(s.started)
+}
+
+// Adds callback that must be called once on close.
+// Returns false if connection is already closed.
+func (s *connState) addCloseFn(closefn func()) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return false
+	}
+	s.closefns = append(s.closefns, closefn)
+	return true
+}
+
+// Marks connection as closed and returns callbacks set by addCloseFn
+func (s *connState) takeCloseFns() []func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	closefns := s.closefns
+	s.closefns = nil
+	s.closed = true
+	return closefns
+}
+
+func (s *connState) snapshot() ConnSnapshot {
+	s.mutex.Lock()
+	info := s.info
+	s.mutex.Unlock()
+	info.BytesIn = s.bytesIn.Load()
+	info.BytesOut = s.bytesOut.Load()
+	info.PacketsIn = s.packetsIn.Load()
+	info.PacketsOut = s.packetsOut.Load()
+	return info
+}
+
+// Counts bytes passed through connection
+type countingConn struct {
+	net.Conn
+	state *connState
+}
+
+func (c *countingConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.state.bytesIn.Add(uint64(n))
+	return
+}
+
+func (c *countingConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	c.state.bytesOut.Add(uint64(n))
+	return
+}
+
+// Total traffic of connections
+type TrafficStats struct {
+	BytesIn    uint64
+	BytesOut   uint64
+	PacketsIn  uint64
+	PacketsOut uint64
+}
+
+func (t *TrafficStats) add(info ConnSnapshot) {
+	t.BytesIn += info.BytesIn
+	t.BytesOut += info.BytesOut
+	t.PacketsIn += info.PacketsIn
+	t.PacketsOut += info.PacketsOut
+}
+
+// Stores all active connections and listeners opened by ConnManager.
+// Closed connections and listeners are removed automatically.
+type connRegistry struct {
+	mutex      sync.Mutex
+	conns      map[*connState]*YggConn
+	listeners  map[uint64]static.TransportListener
+	listenerId uint64
+	closed     bool
+	// Traffic of removed connections
+	closedTraffic TrafficStats
+}
+
+func newConnRegistry() *connRegistry {
+	return &connRegistry{
+		conns:     make(map[*connState]*YggConn),
+		listeners: make(map[uint64]static.TransportListener),
+	}
+}
+
+// Returns false if registry is already closed
+func (r *connRegistry) add(conn *YggConn) bool {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return false
+	}
+	r.conns[conn.state] = conn
+	r.mutex.Unlock()
+	remove := func() {
+		r.mutex.Lock()
+		if _, ok := r.conns[conn.state]; ok {
+			// Under the same lock, so total traffic never decreases
+			r.closedTraffic.add(conn.state.snapshot())
+			delete(r.conns, conn.state)
+		}
+		r.mutex.Unlock()
+	}
+	if !conn.state.addCloseFn(remove) {
+		remove()
+	}
+	return true
+}
+
+// Returns id of listener and false if registry is already closed
+func (r *connRegistry) addListener(listener static.TransportListener) (uint64, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return 0, false
+	}
+	r.listenerId += 1
+	r.listeners[r.listenerId] = listener
+	return r.listenerId, true
+}
+
+func (r *connRegistry) removeListener(id uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.listeners, id)
+}
+
+func (r *connRegistry) isClosed() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closed
+}
+
+// Marks registry as closed and returns all stored listeners and connections.
+// Nothing can be added after that.
+// Next calls return nothing.
+func (r *connRegistry) close() ([]static.TransportListener, []*YggConn) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil, nil
+	}
+	r.closed = true
+	listeners := make([]static.TransportListener, 0, len(r.listeners))
+	for id, listener := range r.listeners {
+		listeners = append(listeners, listener)
+		delete(r.listeners, id)
+	}
+	conns := make([]*YggConn, 0, len(r.conns))
+	for _, conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	return listeners, conns
+}
+
+func (r *connRegistry) snapshots() []ConnSnapshot {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	snapshots := make([]ConnSnapshot, 0, len(r.conns))
+	for state := range r.conns {
+		snapshots = append(snapshots, state.snapshot())
+	}
+	return snapshots
+}
+
+// Returns traffic of active and removed connections
+func (r *connRegistry) traffic() TrafficStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	total := r.closedTraffic
+	for state := range r.conns {
+		total.add(state.snapshot())
+	}
+	return total
+}
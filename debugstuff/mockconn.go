@@ -114,6 +114,32 @@ func MockWrongVerConn() net.Conn {
 	return a
 }
 
+// Return valid handshake pkg & some pseudo payload data
+// but proto version is too old
+func MockConnOldVerContent() []byte {
+	content := MockConnWrongVerContent()
+	content[4] = 0
+	content[5] = 3
+	return content
+}
+
+// Guess what by name
+func MockOldVerConn() net.Conn {
+	a, b := net.Pipe()
+	go func() {
+		buf := make([]byte, 1)
+		b.Write(MockConnOldVerContent())
+		for {
+			_, err := b.Read(buf)
+			if err != nil {
+				break
+			}
+		}
+		b.Close()
+	}()
+	return a
+}
+
 // Returns an incorrect cropped ygg handshake pkg
 func MockConnTooShortContent() []byte {
 	return []byte{
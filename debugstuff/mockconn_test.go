@@ -47,6 +47,18 @@ func TestMockConnWrongVerContent(t *testing.T) {
 	time.Sleep(100)
 }
 
+func TestMockConnOldVerContent(t *testing.T) {
+	bufA := MockConnOldVerContent()
+	bufB := make([]byte, len(bufA))
+	conn := MockOldVerConn()
+	conn.Read(bufB)
+	if bytes.Compare(bufA, bufB) != 0 {
+		t.Fatalf("Must be equal")
+	}
+	conn.Close()
+	time.Sleep(100)
+}
+
 func TestMockConnTooShortContent(t *testing.T) {
 	bufA := MockConnTooShortContent()
 	bufB := make([]byte, len(bufA))
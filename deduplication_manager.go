@@ -20,6 +20,8 @@ import (
 	"bytes"
 	"crypto/ed25519"
 	"encoding/hex"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"log/slog"
 	"sync"
 )
 
@@ -41,6 +43,7 @@ type DeduplicationManager struct {
 	secureMode  bool
 	blockKey    ed25519.PublicKey
 	mutex       sync.Mutex
+	logger      *slog.Logger
 }
 
 // If secureMode is disabled
@@ -59,6 +62,14 @@ func NewDeduplicationManager(secureMode bool, blockKey ed25519.PublicKey) *Dedup
 	}
 }
 
+// Sets logger for deduplication decisions.
+// Nil disables logging.
+func (d *DeduplicationManager) SetLogger(logger *slog.Logger) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.logger = logger
+}
+
 // Callback
 func (d *DeduplicationManager) onClose(strKey string, connId uint64) {
 	d.mutex.Lock()
@@ -84,21 +95,36 @@ func (d *DeduplicationManager) onClose(strKey string, connId uint64) {
 // connection MUST call on close.
 // If it is duplicate and if it must be closed returns nill.
 func (d *DeduplicationManager) Check(key ed25519.PublicKey, isSecure uint, closeMethod func()) func() {
+	// Displaced connection is closed after unlocking
+	// because it calls onClose on close
+	var displaced func() = nil
+	defer func() {
+		if displaced != nil {
+			displaced()
+		}
+	}()
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
+	logger := static.LoggerOrNop(d.logger).With(
+		static.KeyAttr("peer_key", key),
+		"security_level", isSecure,
+	)
 	if d.blockKey != nil && bytes.Compare(d.blockKey, key) == 0 {
+		logger.Info("Connection with blocked key is rejected")
 		return nil
 	}
 	strKey := keyToStr(key)
 	if value, ok := d.connections[strKey]; ok {
 		if !d.secureMode {
+			logger.Info("Duplicate connection is rejected")
 			return nil
 		}
 		if isSecure > value.isSecure {
-			if value.closeMethod != nil {
-				value.closeMethod()
-				value.closeMethod = nil
-			}
+			logger.Info(
+				"Connection displaces less secure duplicate",
+				"displaced_security_level", value.isSecure,
+			)
+			displaced = value.closeMethod
 			connId := d.connId
 			d.connId += 1
 			d.connections[strKey] = connInfo{
@@ -110,6 +136,10 @@ func (d *DeduplicationManager) Check(key ed25519.PublicKey, isSecure uint, close
 				d.onClose(strKey, connId)
 			}
 		}
+		logger.Info(
+			"Duplicate connection is rejected",
+			"existing_security_level", value.isSecure,
+		)
 		return nil
 	}
 	connId := d.connId
@@ -18,6 +18,8 @@ package ytl
 
 import (
 	"crypto/ed25519"
+	"log/slog"
+	"strings"
 	"testing"
 )
 
@@ -147,3 +149,15 @@ func TestNoCollision(t *testing.T) {
 		t.Fatalf("Connection closed")
 	}
 }
+
+func TestDeduplicationManagerLogger(t *testing.T) {
+	output := &syncBuffer{}
+	key := make(ed25519.PublicKey, ed25519.PublicKeySize)
+	manager := NewDeduplicationManager(false, nil)
+	manager.SetLogger(slog.New(slog.NewTextHandler(output, nil)))
+	manager.Check(key, 0, nil)
+	manager.Check(key, 0, nil)
+	if !strings.Contains(output.String(), "msg=\"Duplicate connection is rejected\"") {
+		t.Fatalf("Decision was not logged: %s", output.String())
+	}
+}
@@ -0,0 +1,48 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package dialers
+
+import (
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"syscall"
+)
+
+// Returns control function that sets SO_BINDTODEVICE and SO_MARK
+// socket options or nil if they are not required.
+func bindControl(options static.BindOptions) (func(network, address string, c syscall.RawConn) error, error) {
+	if options.Interface == "" && options.Mark == 0 {
+		return nil, nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		e := c.Control(func(fd uintptr) {
+			if options.Interface != "" {
+				err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, options.Interface)
+				if err != nil {
+					return
+				}
+			}
+			if options.Mark != 0 {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, int(options.Mark))
+			}
+		})
+		if e != nil {
+			return e
+		}
+		return err
+	}, nil
+}
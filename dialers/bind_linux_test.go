@@ -0,0 +1,67 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package dialers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"golang.org/x/sys/unix"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+func TestTcpDialerBindToDevice(t *testing.T) {
+	listener, good := testListener(t)
+	defer listener.Close()
+	loopback := ""
+	interfaces, _ := net.Interfaces()
+	for _, i := range interfaces {
+		if i.Flags&net.FlagLoopback != 0 {
+			loopback = i.Name
+		}
+	}
+	if loopback == "" {
+		t.Skip("Loopback interface is not found")
+	}
+	var options static.BindOptions
+	// Options of dialer are overridden by uri params
+	dialer := TcpDialer{
+		Bind: static.BindOptions{Interface: "ytl-missing0", Mark: 7},
+		Control: func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				name, _ := unix.GetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE)
+				mark, _ := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK)
+				options = static.BindOptions{Interface: name, Mark: uint32(mark)}
+			})
+		},
+	}
+	uri, _ := url.Parse(fmt.Sprintf("tcp://%s?bind=%s&mark=0x10", good.String(), loopback))
+	conn, err := dialer.Dial(*uri, nil)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("Not enough privileges to set socket options")
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	conn.Close()
+	if options.Interface != loopback || options.Mark != 0x10 {
+		t.Errorf("Socket options were not set: %v", options)
+	}
+}
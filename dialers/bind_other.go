@@ -0,0 +1,35 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+//go:build !linux
+
+package dialers
+
+import (
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"syscall"
+)
+
+// SO_BINDTODEVICE and SO_MARK are not supported on this platform.
+func bindControl(options static.BindOptions) (func(network, address string, c syscall.RawConn) error, error) {
+	if options.Interface != "" {
+		return nil, static.UnsupportedSocketOptionError{Option: "SO_BINDTODEVICE"}
+	}
+	if options.Mark != 0 {
+		return nil, static.UnsupportedSocketOptionError{Option: "SO_MARK"}
+	}
+	return nil, nil
+}
@@ -0,0 +1,58 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package dialers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestTcpDialerSource(t *testing.T) {
+	listener, good := testListener(t)
+	defer listener.Close()
+	uri, _ := url.Parse(fmt.Sprintf("tcp://%s?source=127.0.0.2", good.String()))
+	dialer := TcpDialer{}
+	conn, err := dialer.Dial(*uri, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	conn.Close()
+	if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.2")) {
+		t.Errorf("Wrong source address: %s", ip)
+	}
+	uri, _ = url.Parse(fmt.Sprintf("tcp://%s?source=::1", good.String()))
+	if _, err := dialer.Dial(*uri, nil); err == nil {
+		t.Errorf("Address of other family must not be dialed")
+	}
+}
+
+func TestTcpDialerUnknownInterface(t *testing.T) {
+	uri, _ := url.Parse("tcp://127.0.0.1:1?bind=ytl-missing0")
+	dialer := TcpDialer{}
+	_, err := dialer.Dial(*uri, nil)
+	if e, ok := err.(static.UnknownInterfaceError); !ok || e.Name != "ytl-missing0" {
+		t.Errorf("Expected UnknownInterfaceError, got %v", err)
+	}
+	uri, _ = url.Parse("tcp://127.0.0.1:1?source=wtf")
+	if _, err := dialer.Dial(*uri, nil); !errors.As(err, &static.InvalidUriError{}) {
+		t.Errorf("Expected InvalidUriError, got %v", err)
+	}
+}
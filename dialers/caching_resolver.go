@@ -0,0 +1,384 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package dialers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultResolverTTL = time.Minute
+	defaultNegativeTTL = 30 * time.Second
+	// Max size of DNS message over UDP without EDNS
+	maxUdpDnsMessage = 512
+)
+
+type resolverCacheEntry struct {
+	addrs   []net.IPAddr
+	err     error
+	expires time.Time
+}
+
+// CachingResolver implements static.Resolver and caches results
+// until TTL of received records expires.
+// Lookups of missing hosts are cached too (negative caching).
+//
+// By default requests are sent through system resolver and results
+// are cached for DefaultTTL, because system resolver does not report TTL.
+// If Server is set, requests are sent to this DNS server directly.
+// If DoH is set, requests are sent to this DNS over HTTPS endpoint (RFC 8484).
+//
+// Zero value is ready to use.
+// Zero DefaultTTL and NegativeTTL are replaced with defaults.
+type CachingResolver struct {
+	// Address of DNS server, port 53 is used if missing
+	Server string
+	// DNS over HTTPS endpoint like "https://127.0.0.1/dns-query"
+	DoH *url.URL
+	// Client for DoH requests, http.DefaultClient is used if nil
+	HTTPClient *http.Client
+	// TTL of results returned by system resolver
+	DefaultTTL time.Duration `default:"1m"`
+	// TTL of missing host if DNS server does not report it
+	NegativeTTL time.Duration `default:"30s"`
+	// Upper limit of TTL, zero means no limit
+	MaxTTL time.Duration
+
+	mutex sync.Mutex
+	cache map[string]resolverCacheEntry
+	// Replaced in tests
+	now func() time.Time
+}
+
+func (r *CachingResolver) defaultTTL() time.Duration {
+	if r.DefaultTTL == 0 {
+		return defaultResolverTTL
+	}
+	return r.DefaultTTL
+}
+
+func (r *CachingResolver) negativeTTL() time.Duration {
+	if r.NegativeTTL == 0 {
+		return defaultNegativeTTL
+	}
+	return r.NegativeTTL
+}
+
+func (r *CachingResolver) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+// Returns addresses of host from cache or resolves them.
+// Ip literals are returned as is.
+func (r *CachingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []net.IPAddr{{IP: ip.AsSlice(), Zone: ip.Zone()}}, nil
+	}
+	key := strings.ToLower(strings.TrimSuffix(host, "."))
+	r.mutex.Lock()
+	entry, ok := r.cache[key]
+	r.mutex.Unlock()
+	if ok && r.clock().Before(entry.expires) {
+		return append([]net.IPAddr(nil), entry.addrs...), entry.err
+	}
+	addrs, ttl, err := r.lookup(ctx, key)
+	if err != nil && !isNotFound(err) {
+		// Temporary failures are not cached
+		return nil, err
+	}
+	if r.MaxTTL > 0 && ttl > r.MaxTTL {
+		ttl = r.MaxTTL
+	}
+	now := r.clock()
+	r.mutex.Lock()
+	if r.cache == nil {
+		r.cache = make(map[string]resolverCacheEntry)
+	}
+	for name, old := range r.cache {
+		if !now.Before(old.expires) {
+			delete(r.cache, name)
+		}
+	}
+	r.cache[key] = resolverCacheEntry{addrs, err, now.Add(ttl)}
+	r.mutex.Unlock()
+	return append([]net.IPAddr(nil), addrs...), err
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func notFoundError(host string) error {
+	return &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// Resolves host without cache and returns ttl of result
+func (r *CachingResolver) lookup(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	if r.Server == "" && r.DoH == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if isNotFound(err) {
+			return nil, r.negativeTTL(), err
+		}
+		return addrs, r.defaultTTL(), err
+	}
+	type result struct {
+		addrs []net.IPAddr
+		ttl   time.Duration
+		err   error
+	}
+	types := []dnsmessage.Type{dnsmessage.TypeAAAA, dnsmessage.TypeA}
+	results := make(chan result, len(types))
+	for _, qtype := range types {
+		go func() {
+			addrs, ttl, err := r.query(ctx, host, qtype)
+			results <- result{addrs, ttl, err}
+		}()
+	}
+	var addrs []net.IPAddr
+	var ttl time.Duration = -1
+	var negativeTtl time.Duration = -1
+	var err error
+	for range types {
+		res := <-results
+		switch {
+		case res.err == nil:
+			addrs = append(addrs, res.addrs...)
+			if ttl < 0 || res.ttl < ttl {
+				ttl = res.ttl
+			}
+		case isNotFound(res.err):
+			if negativeTtl < 0 || res.ttl < negativeTtl {
+				negativeTtl = res.ttl
+			}
+		case err == nil:
+			err = res.err
+		}
+	}
+	if len(addrs) > 0 {
+		// AAAA records go first, order of results can be any
+		ipv6 := make([]net.IPAddr, 0, len(addrs))
+		ipv4 := make([]net.IPAddr, 0, len(addrs))
+		for _, a := range addrs {
+			if a.IP.To4() == nil {
+				ipv6 = append(ipv6, a)
+			} else {
+				ipv4 = append(ipv4, a)
+			}
+		}
+		return append(ipv6, ipv4...), ttl, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return nil, negativeTtl, notFoundError(host)
+}
+
+// Sends single DNS question and parses answer.
+// Missing records are reported as not found error with negative ttl.
+func (r *CachingResolver) query(
+	ctx context.Context,
+	host string,
+	qtype dnsmessage.Type,
+) ([]net.IPAddr, time.Duration, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host}
+	}
+	id := uint16(rand.Uint32())
+	request := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	if r.DoH != nil {
+		// RFC 8484 recommends zero id for caching
+		request.Header.ID = 0
+	}
+	packed, err := request.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	raw, err := r.exchange(ctx, packed)
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host, Server: r.server()}
+	}
+	var response dnsmessage.Message
+	if err := response.Unpack(raw); err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host, Server: r.server()}
+	}
+	if response.Header.ID != request.Header.ID || !response.Header.Response {
+		return nil, 0, &net.DNSError{Err: "unexpected response", Name: host, Server: r.server()}
+	}
+	switch response.Header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, r.soaTTL(response), notFoundError(host)
+	default:
+		return nil, 0, &net.DNSError{
+			Err:         response.Header.RCode.String(),
+			Name:        host,
+			Server:      r.server(),
+			IsTemporary: response.Header.RCode == dnsmessage.RCodeServerFailure,
+		}
+	}
+	var addrs []net.IPAddr
+	var ttl uint32
+	for _, answer := range response.Answers {
+		var ip net.IP
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(body.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(body.AAAA[:])
+		default:
+			continue
+		}
+		if len(addrs) == 0 || answer.Header.TTL < ttl {
+			ttl = answer.Header.TTL
+		}
+		addrs = append(addrs, net.IPAddr{IP: ip})
+	}
+	if len(addrs) == 0 {
+		return nil, r.soaTTL(response), notFoundError(host)
+	}
+	return addrs, time.Duration(ttl) * time.Second, nil
+}
+
+// Returns ttl of negative answer as RFC 2308 defines
+// or NegativeTTL if answer does not contain SOA record.
+func (r *CachingResolver) soaTTL(response dnsmessage.Message) time.Duration {
+	for _, authority := range response.Authorities {
+		if soa, ok := authority.Body.(*dnsmessage.SOAResource); ok {
+			ttl := min(authority.Header.TTL, soa.MinTTL)
+			return time.Duration(ttl) * time.Second
+		}
+	}
+	return r.negativeTTL()
+}
+
+func (r *CachingResolver) server() string {
+	if r.DoH != nil {
+		return r.DoH.Redacted()
+	}
+	if _, _, err := net.SplitHostPort(r.Server); err != nil {
+		return net.JoinHostPort(r.Server, "53")
+	}
+	return r.Server
+}
+
+// Sends packed DNS message and returns packed answer
+func (r *CachingResolver) exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	if r.DoH != nil {
+		return r.exchangeHttps(ctx, msg)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+	answer, err := exchangeConn(ctx, "udp", r.server(), msg)
+	if err != nil {
+		return nil, err
+	}
+	var header dnsmessage.Header
+	var parser dnsmessage.Parser
+	if header, err = parser.Start(answer); err == nil && header.Truncated {
+		return exchangeConn(ctx, "tcp", r.server(), msg)
+	}
+	return answer, nil
+}
+
+func exchangeConn(ctx context.Context, network, server string, msg []byte) ([]byte, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+	if network == "udp" {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, maxUdpDnsMessage)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+	if _, err := conn.Write(append(framed, msg...)); err != nil {
+		return nil, err
+	}
+	var size uint16
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (r *CachingResolver) exchangeHttps(ctx context.Context, msg []byte) ([]byte, error) {
+	client := r.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.DoH.String(), bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/dns-message")
+	request.Header.Set("Accept", "application/dns-message")
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected http status %s", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<16))
+}
@@ -0,0 +1,211 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package dialers
+
+import (
+	"context"
+	"fmt"
+	"github.com/foxcpp/go-mockdns"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func testDnsServer(t *testing.T) *mockdns.Server {
+	srv, err := mockdns.NewServer(map[string]mockdns.Zone{
+		"dual.org.": {
+			AAAA: []string{"::1"},
+			A:    []string{"127.0.0.1"},
+		},
+	}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return srv
+}
+
+func TestCachingResolverServer(t *testing.T) {
+	srv := testDnsServer(t)
+	defer srv.Close()
+	clock := &fakeClock{time.Now()}
+	resolver := &CachingResolver{Server: srv.LocalAddr().String(), now: clock.Now}
+	ctx := context.Background()
+	addrs, err := resolver.LookupIPAddr(ctx, "dual.org")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if fmt.Sprint(addrs) != "[{::1 } {127.0.0.1 }]" {
+		t.Errorf("Unexpected addresses: %v", addrs)
+	}
+	_, err = resolver.LookupIPAddr(ctx, "missing.org")
+	if !isNotFound(err) {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+	srv.Close()
+	if cached, err := resolver.LookupIPAddr(ctx, "DUAL.org."); err != nil || len(cached) != 2 {
+		t.Errorf("Result must be cached: %v %v", cached, err)
+	}
+	if _, err := resolver.LookupIPAddr(ctx, "missing.org"); !isNotFound(err) {
+		t.Errorf("Missing host must be cached: %v", err)
+	}
+	// Mockdns uses 60s as min ttl of SOA record
+	clock.now = clock.now.Add(61 * time.Second)
+	if _, err := resolver.LookupIPAddr(ctx, "missing.org"); err == nil || isNotFound(err) {
+		t.Errorf("Missing host must expire: %v", err)
+	}
+	if _, err := resolver.LookupIPAddr(ctx, "dual.org"); err != nil {
+		t.Errorf("Result must be cached until ttl expires: %s", err)
+	}
+	// Mockdns uses 9999s ttl for all records
+	clock.now = clock.now.Add(9999 * time.Second)
+	if _, err := resolver.LookupIPAddr(ctx, "dual.org"); err == nil {
+		t.Errorf("Result must expire")
+	}
+}
+
+func TestCachingResolverMaxTTL(t *testing.T) {
+	srv := testDnsServer(t)
+	defer srv.Close()
+	clock := &fakeClock{time.Now()}
+	resolver := &CachingResolver{
+		Server: srv.LocalAddr().String(),
+		MaxTTL: time.Second,
+		now:    clock.Now,
+	}
+	if _, err := resolver.LookupIPAddr(context.Background(), "dual.org"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	srv.Close()
+	clock.now = clock.now.Add(2 * time.Second)
+	if _, err := resolver.LookupIPAddr(context.Background(), "dual.org"); err == nil {
+		t.Errorf("Ttl must be limited by MaxTTL")
+	}
+}
+
+func TestCachingResolverSystem(t *testing.T) {
+	srv := testDnsServer(t)
+	defer srv.Close()
+	srv.PatchNet(net.DefaultResolver)
+	defer mockdns.UnpatchNet(net.DefaultResolver)
+	clock := &fakeClock{time.Now()}
+	resolver := &CachingResolver{now: clock.Now}
+	if _, err := resolver.LookupIPAddr(context.Background(), "dual.org"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	srv.Close()
+	if _, err := resolver.LookupIPAddr(context.Background(), "dual.org"); err != nil {
+		t.Errorf("Result must be cached: %s", err)
+	}
+	clock.now = clock.now.Add(defaultResolverTTL)
+	if _, err := resolver.LookupIPAddr(context.Background(), "dual.org"); err == nil {
+		t.Errorf("Result must expire after DefaultTTL")
+	}
+}
+
+func TestCachingResolverDoH(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var request dnsmessage.Message
+		if err := request.Unpack(body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		question := request.Questions[0]
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: request.ID, Response: true},
+			Questions: request.Questions,
+		}
+		if question.Type == dnsmessage.TypeA {
+			response.Answers = append(response.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{
+					Name:  question.Name,
+					Type:  dnsmessage.TypeA,
+					Class: dnsmessage.ClassINET,
+					TTL:   5,
+				},
+				Body: &dnsmessage.AResource{A: [4]byte{127, 0, 0, 2}},
+			})
+		}
+		packed, _ := response.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	defer server.Close()
+	endpoint, _ := url.Parse(server.URL + "/dns-query")
+	clock := &fakeClock{time.Now()}
+	resolver := &CachingResolver{DoH: endpoint, now: clock.Now}
+	for i := 0; i < 3; i++ {
+		addrs, err := resolver.LookupIPAddr(context.Background(), "doh.org")
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if len(addrs) != 1 || !addrs[0].IP.Equal(net.IPv4(127, 0, 0, 2)) {
+			t.Fatalf("Unexpected addresses: %v", addrs)
+		}
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected 2 requests (A and AAAA), got %d", requests.Load())
+	}
+	clock.now = clock.now.Add(5 * time.Second)
+	resolver.LookupIPAddr(context.Background(), "doh.org")
+	if requests.Load() != 4 {
+		t.Errorf("Result must expire after ttl, got %d requests", requests.Load())
+	}
+}
+
+type staticResolver map[string][]net.IPAddr
+
+func (r staticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return r[host], nil
+}
+
+func TestTcpDialerResolver(t *testing.T) {
+	listener, good := testListener(t)
+	defer listener.Close()
+	resolver := staticResolver{"fake.host": {{IP: good.IP}}}
+	uri, _ := url.Parse(fmt.Sprintf("tcp://fake.host:%d", good.Port))
+	dialer := TcpDialer{Resolver: resolver}
+	conn, err := dialer.Dial(*uri, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	conn.Close()
+	uri, _ = url.Parse(fmt.Sprintf("tcp://empty.host:%d", good.Port))
+	if _, err = dialer.Dial(*uri, nil); !isNotFound(err) {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+}
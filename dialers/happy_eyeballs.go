@@ -0,0 +1,160 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package dialers
+
+import (
+	"context"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Resolves all ip addresses of "host:port".
+// Ip literals are returned as is without dns requests.
+func resolveTcpAddrs(
+	ctx context.Context,
+	resolver static.Resolver,
+	hostport string,
+) ([]*net.TCPAddr, error) {
+	host, service, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, "tcp", service)
+	if err != nil {
+		return nil, err
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []*net.TCPAddr{{IP: ip.AsSlice(), Port: port, Zone: ip.Zone()}}, nil
+	}
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]*net.TCPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, &net.TCPAddr{IP: ip.IP, Port: port, Zone: ip.Zone})
+	}
+	return addrs, nil
+}
+
+// Orders addresses as RFC 8305 recommends:
+// families alternate starting with the family of the first address,
+// order inside family is kept.
+func interleaveAddrs(addrs []*net.TCPAddr) []*net.TCPAddr {
+	if len(addrs) == 0 {
+		return addrs
+	}
+	isFirstFamily := func(a *net.TCPAddr) bool {
+		return (a.IP.To4() == nil) == (addrs[0].IP.To4() == nil)
+	}
+	first := make([]*net.TCPAddr, 0, len(addrs))
+	second := make([]*net.TCPAddr, 0, len(addrs))
+	for _, a := range addrs {
+		if isFirstFamily(a) {
+			first = append(first, a)
+		} else {
+			second = append(second, a)
+		}
+	}
+	result := make([]*net.TCPAddr, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			result = append(result, first[i])
+		}
+		if i < len(second) {
+			result = append(result, second[i])
+		}
+	}
+	return result
+}
+
+// Dials addresses one by one, starting next attempt
+// after attemptDelay or as soon as previous one fails.
+// Attempts are not stopped while next ones are started,
+// first established connection wins and others are cancelled.
+//
+// If all attempts fail, error of the first one is returned.
+// Connections established after the winner are closed in background.
+func (d *TcpDialer) dialParallel(
+	ctx context.Context,
+	innerDialer *net.Dialer,
+	addrs []*net.TCPAddr,
+	logger *slog.Logger,
+) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+		addr *net.TCPAddr
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, len(addrs))
+	started, pending := 0, 0
+	var firstErr error
+	for {
+		if started < len(addrs) {
+			dst := addrs[started]
+			logger.Debug("Dialing", "address", dst.String())
+			go func() {
+				conn, err := innerDialer.DialContext(ctx, "tcp", dst.String())
+				results <- result{conn, err, dst}
+			}()
+			started++
+			pending++
+		}
+		var next <-chan time.Time
+		var timer *time.Timer
+		if started < len(addrs) {
+			timer = time.NewTimer(d.attemptDelay())
+			next = timer.C
+		}
+		select {
+		case <-next:
+			continue
+		case r := <-results:
+			if timer != nil {
+				timer.Stop()
+			}
+			pending--
+			if r.err == nil {
+				if pending > 0 {
+					go func() {
+						for ; pending > 0; pending-- {
+							if late := <-results; late.conn != nil {
+								late.conn.Close()
+							}
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			logger.Debug("Dial failed", "address", r.addr.String(), "error", r.err)
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if pending == 0 && started == len(addrs) {
+				return nil, firstErr
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package dialers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"github.com/foxcpp/go-mockdns"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInterleaveAddrs(t *testing.T) {
+	parse := func(ips ...string) []*net.TCPAddr {
+		addrs := make([]*net.TCPAddr, 0, len(ips))
+		for _, ip := range ips {
+			addrs = append(addrs, &net.TCPAddr{IP: net.ParseIP(ip), Port: 1})
+		}
+		return addrs
+	}
+	cases := [][2][]*net.TCPAddr{
+		{parse(), parse()},
+		{parse("::1", "::2", "::3", "1.1.1.1"), parse("::1", "1.1.1.1", "::2", "::3")},
+		{parse("1.1.1.1", "1.1.1.2", "::1", "::2"), parse("1.1.1.1", "::1", "1.1.1.2", "::2")},
+		{parse("1.1.1.1", "1.1.1.2"), parse("1.1.1.1", "1.1.1.2")},
+	}
+	for _, c := range cases {
+		result := interleaveAddrs(c[0])
+		if fmt.Sprint(result) != fmt.Sprint(c[1]) {
+			t.Errorf("Expected %v, got %v", c[1], result)
+		}
+	}
+}
+
+func testListener(t *testing.T) (net.Listener, *net.TCPAddr) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener, listener.Addr().(*net.TCPAddr)
+}
+
+// Returns address of closed port
+func closedAddr(t *testing.T) *net.TCPAddr {
+	listener, addr := testListener(t)
+	listener.Close()
+	return addr
+}
+
+func TestDialParallelStaggered(t *testing.T) {
+	listener, good := testListener(t)
+	defer listener.Close()
+	slow := &net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: good.Port}
+	release := make(chan struct{})
+	defer close(release)
+	dialer := TcpDialer{
+		AttemptDelay: 50 * time.Millisecond,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if address == slow.String() {
+				<-release
+				return errors.New("slow address")
+			}
+			return nil
+		},
+	}
+	started := time.Now()
+	netDialer, _ := dialer.netDialer(static.BindOptions{})
+	conn, err := dialer.dialParallel(
+		context.Background(),
+		netDialer,
+		[]*net.TCPAddr{slow, good},
+		static.NopLogger(),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != good.String() {
+		t.Errorf("Wrong address is used: %s", conn.RemoteAddr())
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Second attempt started too late: %s", elapsed)
+	}
+}
+
+func TestDialParallelFailFast(t *testing.T) {
+	listener, good := testListener(t)
+	defer listener.Close()
+	dialer := TcpDialer{AttemptDelay: time.Minute}
+	started := time.Now()
+	netDialer, _ := dialer.netDialer(static.BindOptions{})
+	conn, err := dialer.dialParallel(
+		context.Background(),
+		netDialer,
+		[]*net.TCPAddr{closedAddr(t), good},
+		static.NopLogger(),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	conn.Close()
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("Failed attempt must start next one immediately: %s", elapsed)
+	}
+	first, second := closedAddr(t), closedAddr(t)
+	_, err = dialer.dialParallel(
+		context.Background(),
+		netDialer,
+		[]*net.TCPAddr{first, second},
+		static.NopLogger(),
+	)
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Addr.String() != first.String() {
+		t.Errorf("Error of the first attempt must be returned: %v", err)
+	}
+}
+
+// Checking that all resolved addresses are tried
+// and addresses in ygg range are skipped
+func TestTcpDialerHappyEyeballs(t *testing.T) {
+	listener, good := testListener(t)
+	defer listener.Close()
+	srv, _ := mockdns.NewServer(map[string]mockdns.Zone{
+		"dual.org.": {
+			AAAA: []string{"202:a029:6fa0:f079:7fc:646f:cd3b:6248"},
+			A:    []string{"127.0.0.1"},
+		},
+	}, false)
+	defer srv.Close()
+	srv.PatchNet(net.DefaultResolver)
+	defer mockdns.UnpatchNet(net.DefaultResolver)
+	uri, _ := url.Parse(fmt.Sprintf("tcp://dual.org:%d", good.Port))
+	dialer := TcpDialer{}
+	conn, err := dialer.Dial(*uri, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != good.String() {
+		t.Errorf("Wrong address is used: %s", conn.RemoteAddr())
+	}
+}
@@ -0,0 +1,169 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package dialers
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"golang.org/x/net/proxy"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Returns true if TcpDialer can connect via proxy with such scheme
+func isSupportedProxy(scheme string) bool {
+	switch scheme {
+	case "socks", "socks5", "socks5h", "http", "https":
+		return true
+	}
+	return false
+}
+
+// Returns dialer that connects to addresses via proxy.
+// Proxy is reached at proxyAddr with forward dialer.
+func proxyDialer(proxyUri *url.URL, proxyAddr string, forward proxy.Dialer) (proxy.ContextDialer, error) {
+	switch proxyUri.Scheme {
+	case "http", "https":
+		return &httpProxyDialer{proxyUri, proxyAddr, forward}, nil
+	}
+	auth := &proxy.Auth{}
+	if proxyUri.User != nil {
+		auth.User = proxyUri.User.Username()
+		auth.Password, _ = proxyUri.User.Password()
+	}
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, auth, forward)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.(proxy.ContextDialer), nil
+}
+
+// Wraps errors of proxy dialer with number of hop.
+// Errors already wrapped by previous hops are kept as is.
+type hopDialer struct {
+	hop    int
+	uri    *url.URL
+	dialer proxy.ContextDialer
+}
+
+func (d *hopDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *hopDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		var hopErr static.ProxyHopError
+		if !errors.As(err, &hopErr) {
+			err = static.ProxyHopError{Hop: d.hop, Proxy: *d.uri, Err: err}
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Connects to addresses with HTTP CONNECT method.
+// Basic auth is used if proxy uri has userinfo.
+type httpProxyDialer struct {
+	uri     *url.URL
+	addr    string
+	forward proxy.Dialer
+}
+
+func (d *httpProxyDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *httpProxyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if forward, ok := d.forward.(proxy.ContextDialer); ok {
+		conn, err = forward.DialContext(ctx, "tcp", d.addr)
+	} else {
+		conn, err = d.forward.Dial("tcp", d.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.uri.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.uri.Hostname()})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if d.uri.User != nil {
+		password, _ := d.uri.User.Password()
+		credentials := d.uri.User.Username() + ":" + password
+		request.Header.Set(
+			"Proxy-Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)),
+		)
+	}
+	reader := bufio.NewReader(conn)
+	var response *http.Response
+	if err = request.Write(conn); err == nil {
+		response, err = http.ReadResponse(reader, request)
+	}
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT request: %s", response.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	if reader.Buffered() > 0 {
+		return &bufferedConn{conn, reader}, nil
+	}
+	return conn, nil
+}
+
+// Connection that returns data read ahead first
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,184 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package dialers
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Starts listener that writes greeting to each connection
+func greetingListener(t *testing.T, greeting string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(greeting))
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+// Starts HTTP CONNECT proxy that requires passed Proxy-Authorization
+// and writes early data right after response
+func connectProxy(t *testing.T, auth string, early string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				request, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if request.Method != http.MethodConnect || request.Header.Get("Proxy-Authorization") != auth {
+					conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+					return
+				}
+				target, err := net.Dial("tcp", request.Host)
+				if err != nil {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer target.Close()
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n" + early))
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestTcpDialerHttpProxy(t *testing.T) {
+	target := greetingListener(t, "hello")
+	defer target.Close()
+	// "user:pass" in base64
+	proxyListener := connectProxy(t, "Basic dXNlcjpwYXNz", "early ")
+	defer proxyListener.Close()
+	uri, _ := url.Parse(fmt.Sprintf("tcp://%s", target.Addr().String()))
+	proxy, _ := url.Parse(fmt.Sprintf("http://user:pass@%s", proxyListener.Addr().String()))
+	dialer := TcpDialer{}
+	conn, err := dialer.Dial(*uri, proxy)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer conn.Close()
+	data, _ := io.ReadAll(conn)
+	if string(data) != "early hello" {
+		t.Errorf("Unexpected data: %s", data)
+	}
+	proxy, _ = url.Parse(fmt.Sprintf("http://user:wrong@%s", proxyListener.Addr().String()))
+	if _, err := dialer.Dial(*uri, proxy); err == nil {
+		t.Errorf("Refused CONNECT request must cause error")
+	}
+}
+
+func TestTcpDialerHttpsProxy(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	uri, _ := url.Parse("tcp://127.0.0.1:1")
+	proxy, _ := url.Parse("https://" + server.Listener.Addr().String())
+	dialer := TcpDialer{}
+	_, err := dialer.Dial(*uri, proxy)
+	var certErr *tls.CertificateVerificationError
+	if !errors.As(err, &certErr) {
+		t.Errorf("Proxy connection must use tls, got %v", err)
+	}
+}
+
+func TestTcpDialerInapplicableProxy(t *testing.T) {
+	uri, _ := url.Parse("tcp://127.0.0.1:1")
+	proxy, _ := url.Parse("ftp://127.0.0.1:2")
+	dialer := TcpDialer{}
+	_, err := dialer.Dial(*uri, proxy)
+	if _, ok := err.(static.InapplicableProxyTypeError); !ok {
+		t.Errorf("Expected InapplicableProxyTypeError, got %v", err)
+	}
+}
+
+func TestTcpDialerProxyChain(t *testing.T) {
+	target := greetingListener(t, "hello")
+	defer target.Close()
+	first := connectProxy(t, "", "")
+	defer first.Close()
+	second := connectProxy(t, "Basic dXNlcjpwYXNz", "")
+	defer second.Close()
+	uri, _ := url.Parse(fmt.Sprintf("tcp://%s", target.Addr().String()))
+	firstUri, _ := url.Parse(fmt.Sprintf("http://%s", first.Addr().String()))
+	secondUri, _ := url.Parse(fmt.Sprintf("http://user:pass@%s", second.Addr().String()))
+	dialer := TcpDialer{}
+	conn, err := dialer.DialChainContext(context.Background(), *uri, []*url.URL{firstUri, secondUri})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	data, _ := io.ReadAll(conn)
+	conn.Close()
+	if string(data) != "hello" {
+		t.Errorf("Unexpected data: %s", data)
+	}
+
+	wrongUri, _ := url.Parse(fmt.Sprintf("http://user:wrong@%s", second.Addr().String()))
+	_, err = dialer.DialChainContext(context.Background(), *uri, []*url.URL{firstUri, wrongUri})
+	var hopErr static.ProxyHopError
+	if !errors.As(err, &hopErr) || hopErr.Hop != 2 || hopErr.Proxy.Host != second.Addr().String() {
+		t.Errorf("Error of the second hop expected, got %v", err)
+	}
+	if strings.Contains(err.Error(), "wrong") {
+		t.Errorf("Password must be redacted: %s", err)
+	}
+
+	deadUri, _ := url.Parse(fmt.Sprintf("http://%s", closedAddr(t).String()))
+	_, err = dialer.DialChainContext(context.Background(), *uri, []*url.URL{deadUri, secondUri})
+	if !errors.As(err, &hopErr) || hopErr.Hop != 1 {
+		t.Errorf("Error of the first hop expected, got %v", err)
+	}
+
+	ftpUri, _ := url.Parse("ftp://127.0.0.1:1")
+	_, err = dialer.DialChainContext(context.Background(), *uri, []*url.URL{firstUri, ftpUri})
+	if e, ok := err.(static.InapplicableProxyTypeError); !ok || e.Proxy != *ftpUri {
+		t.Errorf("Expected InapplicableProxyTypeError, got %v", err)
+	}
+}
@@ -18,20 +18,115 @@ package dialers
 
 import (
 	"context"
+	"errors"
 	"github.com/Yggdrasil-Unofficial/ytl/addr"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
 	"golang.org/x/net/proxy"
+	"log/slog"
 	"net"
 	"net/url"
 	"syscall"
 	"time"
 )
 
+const (
+	defaultTimeout      = 2 * time.Minute
+	defaultKeepAlive    = 15 * time.Second
+	defaultAttemptDelay = 250 * time.Millisecond
+)
+
 // Implemets options for connecting to tcp/ip address
 // with some extra features
+//
+// Direct connections are established as RFC 8305 (Happy Eyeballs) recommends:
+// all resolved addresses are dialed with AttemptDelay between starts
+// and the first established connection is used.
+//
+// Zero Timeout, KeepAlive and AttemptDelay are replaced with defaults.
+//
+// If Logger is nil, nothing is logged.
+// If Resolver is nil, system resolver is used.
+//
+// Bind options are overridden by "bind", "source" and "mark" params of dialed uri.
+// Bind options are applied to connection with proxy if it is used.
 type TcpDialer struct {
 	Timeout   time.Duration `default:"2m"`
 	KeepAlive time.Duration `default:"15s"`
-	Control   func(network, address string, c syscall.RawConn) error
+	// Delay before the next connection attempt starts
+	AttemptDelay time.Duration `default:"250ms"`
+	Control      func(network, address string, c syscall.RawConn) error
+	Logger       *slog.Logger
+	Resolver     static.Resolver
+	Bind         static.BindOptions
+}
+
+func (d *TcpDialer) logger() *slog.Logger {
+	return static.LoggerOrNop(d.Logger)
+}
+
+func (d *TcpDialer) resolver() static.Resolver {
+	return static.ResolverOrDefault(d.Resolver)
+}
+
+func (d *TcpDialer) timeout() time.Duration {
+	if d.Timeout == 0 {
+		return defaultTimeout
+	}
+	return d.Timeout
+}
+
+func (d *TcpDialer) attemptDelay() time.Duration {
+	if d.AttemptDelay == 0 {
+		return defaultAttemptDelay
+	}
+	return d.AttemptDelay
+}
+
+func (d *TcpDialer) keepAlive() time.Duration {
+	if d.KeepAlive == 0 {
+		return defaultKeepAlive
+	}
+	return d.KeepAlive
+}
+
+// Returns dialer with bind options applied
+func (d *TcpDialer) netDialer(bind static.BindOptions) (*net.Dialer, error) {
+	if bind.Interface != "" {
+		if _, err := net.InterfaceByName(bind.Interface); err != nil {
+			return nil, static.UnknownInterfaceError{Name: bind.Interface}
+		}
+	}
+	control, err := bindControl(bind)
+	if err != nil {
+		return nil, err
+	}
+	if d.Control != nil {
+		if control == nil {
+			control = d.Control
+		} else {
+			bindControl := control
+			control = func(network, address string, c syscall.RawConn) error {
+				if err := bindControl(network, address, c); err != nil {
+					return err
+				}
+				return d.Control(network, address, c)
+			}
+		}
+	}
+	dialer := &net.Dialer{
+		Timeout:   d.timeout(),
+		KeepAlive: d.keepAlive(),
+		Control:   control,
+	}
+	if bind.Source != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: bind.Source}
+	}
+	return dialer, nil
+}
+
+// Returns true if address can be dialed from source address
+func sameFamily(source net.IP, a *net.TCPAddr) bool {
+	return source == nil || (source.To4() == nil) == (a.IP.To4() == nil)
 }
 
 // Dial connects to the address by url with optional using proxy (if not nil).
@@ -42,65 +137,151 @@ func (d *TcpDialer) Dial(uri url.URL, proxy *url.URL) (net.Conn, error) {
 
 // Dial connects to the address by url with optional using proxy (if not nil).
 // It also drops ygg over ygg connections.
+//
+// Socks5 ("socks", "socks5" and "socks5h" schemes) and
+// HTTP CONNECT ("http" and "https" schemes) proxies are supported,
+// credentials are taken from proxy uri userinfo.
+// Other proxies cause static.InapplicableProxyTypeError.
+//
 // It also accepts a context that allows you to
 // cancel the process of settling ahead of time.
 func (d *TcpDialer) DialContext(ctx context.Context, uri url.URL, proxy_uri *url.URL) (net.Conn, error) {
+	var chain []*url.URL = nil
+	if proxy_uri != nil {
+		chain = []*url.URL{proxy_uri}
+	}
+	return d.DialChainContext(ctx, uri, chain)
+}
+
+// Same as DialContext but tunnels connection through each proxy of chain in turn.
+// Only address of the first proxy is resolved locally and checked.
+// Errors of proxies are wrapped with static.ProxyHopError.
+//
+// Empty chain means direct connection.
+func (d *TcpDialer) DialChainContext(ctx context.Context, uri url.URL, chain []*url.URL) (net.Conn, error) {
 	// Clean code? Cyclomatic complexity?
 	// I dont know these buzzwords
-	use_proxy := false
-	if proxy_uri != nil {
-		use_proxy = proxy_uri.Scheme == "socks" || proxy_uri.Scheme == "socks5" || proxy_uri.Scheme == "socks5h"
+	use_proxy := len(chain) > 0
+	for _, hop := range chain {
+		if !isSupportedProxy(hop.Scheme) {
+			return nil, static.InapplicableProxyTypeError{
+				Transport: uri.Scheme,
+				Proxy:     *hop,
+			}
+		}
+	}
+	logger := d.logger().With("host", uri.Host)
+	peer, err := static.PeerURIFromURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	bind := d.Bind.Override(peer.BindOptions())
+	if !bind.IsZero() {
+		logger = logger.With(
+			"source", bind.Source.String(),
+			"interface", bind.Interface,
+			"mark", bind.Mark,
+		)
+	}
+	netDialer, err := d.netDialer(bind)
+	if err != nil {
+		logger.Warn("Bind options are rejected", "error", err)
+		return nil, err
 	}
 	if use_proxy {
-		dialerdst, err := net.ResolveTCPAddr("tcp", proxy_uri.Host)
+		proxy_uri := chain[0]
+		logger = logger.With("proxy", static.RedactedURI(*proxy_uri))
+		if len(chain) > 1 {
+			hops := make([]string, 0, len(chain))
+			for _, hop := range chain {
+				hops = append(hops, static.RedactedURI(*hop))
+			}
+			logger = logger.With("proxy_chain", hops)
+		}
+		proxyAddrs, err := resolveTcpAddrs(ctx, d.resolver(), proxy_uri.Host)
 		if err != nil {
-			return nil, err
+			logger.Debug("Can not resolve proxy address", "error", err)
+			return nil, static.ProxyHopError{Hop: 1, Proxy: *proxy_uri, Err: err}
+		}
+		dialerdst := proxyAddrs[0]
+		for _, a := range proxyAddrs {
+			if sameFamily(bind.Source, a) {
+				dialerdst = a
+				break
+			}
 		}
 		if err = addr.CheckAddr(dialerdst.IP); err != nil {
-			return nil, err
+			logger.Warn("Proxy address is rejected", "address", dialerdst.IP.String(), "error", err)
+			return nil, static.ProxyHopError{Hop: 1, Proxy: *proxy_uri, Err: err}
 		}
-		auth := &proxy.Auth{}
-		if proxy_uri.User != nil {
-			auth.User = proxy_uri.User.Username()
-			auth.Password, _ = proxy_uri.User.Password()
+		var innerDialer proxy.ContextDialer
+		var forward proxy.Dialer = netDialer
+		hopAddr := dialerdst.String()
+		for i, hop := range chain {
+			if i > 0 {
+				// Next hop is resolved by previous one
+				hopAddr = hop.Host
+			}
+			dialer, err := proxyDialer(hop, hopAddr, forward)
+			if err != nil {
+				return nil, static.ProxyHopError{Hop: i + 1, Proxy: *hop, Err: err}
+			}
+			innerDialer = &hopDialer{i + 1, hop, dialer}
+			forward = innerDialer.(proxy.Dialer)
 		}
-		innerDialer, err := proxy.SOCKS5("tcp", dialerdst.String(), auth, proxy.Direct)
-		if err != nil {
-			return nil, err
-		}
-		ctx, cancel := context.WithTimeout(ctx, d.Timeout)
-		conn, err := innerDialer.(proxy.ContextDialer).DialContext(ctx, "tcp", uri.Host)
+		ctx, cancel := context.WithTimeout(ctx, d.timeout())
+		logger.Debug("Dialing via proxy")
+		conn, err := innerDialer.DialContext(ctx, "tcp", uri.Host)
 		cancel()
 		if err != nil {
+			logger.Debug("Dial failed", "error", err)
 			return nil, err
 		}
-		laddr, _, _ := net.SplitHostPort(conn.LocalAddr().String())
-		raddr, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-		if err = addr.CheckAddr(net.ParseIP(laddr)); err != nil {
+		if err = addr.CheckNetAddr(conn.LocalAddr()); err != nil {
+			logger.Warn("Local address is rejected", "address", conn.LocalAddr().String(), "error", err)
 			conn.Close()
 			return nil, err
 		}
-		if err = addr.CheckAddr(net.ParseIP(raddr)); err != nil {
+		if err = addr.CheckNetAddr(conn.RemoteAddr()); err != nil {
+			logger.Warn("Remote address is rejected", "address", conn.RemoteAddr().String(), "error", err)
 			conn.Close()
 			return nil, err
 		}
 		return conn, err
 	} else {
-		dst, err := net.ResolveTCPAddr("tcp", uri.Host)
+		ctx, cancel := context.WithTimeout(ctx, d.timeout())
+		defer cancel()
+		resolved, err := resolveTcpAddrs(ctx, d.resolver(), uri.Host)
 		if err != nil {
+			logger.Debug("Can not resolve address", "error", err)
 			return nil, err
 		}
-		if err = addr.CheckAddr(dst.IP); err != nil {
-			return nil, err
+		dsts := make([]*net.TCPAddr, 0, len(resolved))
+		for _, dst := range resolved {
+			if e := addr.CheckAddr(dst.IP); e != nil {
+				logger.Warn("Address is rejected", "address", dst.IP.String(), "error", e)
+				if err == nil {
+					err = e
+				}
+				continue
+			}
+			if !sameFamily(bind.Source, dst) {
+				logger.Debug("Address family differs from source", "address", dst.IP.String())
+				continue
+			}
+			dsts = append(dsts, dst)
 		}
-		innerDialer := net.Dialer{
-			Timeout:   d.Timeout,
-			KeepAlive: d.KeepAlive,
-			Control:   d.Control,
+		if len(dsts) == 0 {
+			if err == nil {
+				err = &net.OpError{
+					Op:     "dial",
+					Net:    "tcp",
+					Source: &net.TCPAddr{IP: bind.Source},
+					Err:    errors.New("no address of the same family as source"),
+				}
+			}
+			return nil, err
 		}
-		ctx, cancel := context.WithTimeout(ctx, d.Timeout)
-		conn, err := innerDialer.DialContext(ctx, "tcp", dst.String())
-		cancel()
-		return conn, err
+		return d.dialParallel(ctx, netDialer, interleaveAddrs(dsts), logger)
 	}
 }
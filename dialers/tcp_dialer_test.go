@@ -17,10 +17,14 @@
 package dialers
 
 import (
+	"context"
+	"errors"
 	"github.com/Yggdrasil-Unofficial/ytl/static"
 	"github.com/foxcpp/go-mockdns"
+	"log/slog"
 	"net"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -33,17 +37,21 @@ func testTcpDialerLoopRoutingProtection(
 	}.Error()
 	dialer := TcpDialer{}
 	_, err := dialer.Dial(addr, proxy)
+	// Errors of proxy are wrapped with static.ProxyHopError
+	var addrErr static.UnacceptableAddressError
+	isLoopErr := errors.As(err, &addrErr) && addrErr.Error() == correct_error_text
 	if isYggOverYgg {
 		if err == nil {
 			t.Errorf("Try ygg over ygg routing must retrun error")
-		} else if err.Error() != correct_error_text {
+		} else if !isLoopErr {
 			t.Errorf("Wrong error %s", err)
 		}
+		if _, ok := err.(static.ProxyHopError); proxy != nil && !ok {
+			t.Errorf("Error of proxy must be wrapped: %s", err)
+		}
 	} else {
-		if err != nil {
-			if err.Error() == correct_error_text {
-				t.Errorf("Unexcepted ygg over ygg routing error")
-			}
+		if isLoopErr {
+			t.Errorf("Unexcepted ygg over ygg routing error")
 		}
 	}
 }
@@ -72,3 +80,16 @@ func TestTcpDialerLoopRoutingProtectionWithDns(t *testing.T) {
 	testTcpDialerLoopRoutingProtection(t, *normal_addr, nil, false)
 	testTcpDialerLoopRoutingProtection(t, *normal_addr, normal_proxy, false)
 }
+
+func TestTcpDialerLogger(t *testing.T) {
+	uri, _ := url.Parse("tcp://[200::1]:1000")
+	var output strings.Builder
+	dialer := TcpDialer{Logger: slog.New(slog.NewTextHandler(&output, nil))}
+	dialer.Dial(*uri, nil)
+	if !strings.Contains(output.String(), "msg=\"Address is rejected\"") {
+		t.Errorf("Rejection was not logged: %s", output.String())
+	}
+	// Nil logger must not panic
+	dialer = TcpDialer{}
+	dialer.DialContext(context.Background(), *uri, nil)
+}
@@ -1,10 +1,27 @@
 module github.com/Yggdrasil-Unofficial/ytl
 
-go 1.16
+go 1.22
 
 require (
+	github.com/coder/websocket v1.8.13
 	github.com/foxcpp/go-mockdns v1.0.0
+	github.com/hjson/hjson-go/v4 v4.4.0
+	github.com/quic-go/quic-go v0.48.2
 	github.com/yggdrasil-network/yggdrasil-go v0.4.4
-	go.uber.org/goleak v1.2.0 // indirect
-	golang.org/x/net v0.0.0-20220805013720-a33c5aa5df48
+	go.uber.org/goleak v1.2.0
+	golang.org/x/crypto v0.26.0
+	golang.org/x/net v0.28.0
+	golang.org/x/sys v0.23.0
+)
+
+require (
+	github.com/go-task/slim-sprig v0.0.0-20230315185526-52ccab3ef572 // indirect
+	github.com/google/pprof v0.0.0-20210720184732-4bb14d4b1be1 // indirect
+	github.com/miekg/dns v1.1.25 // indirect
+	github.com/onsi/ginkgo/v2 v2.9.5 // indirect
+	go.uber.org/mock v0.4.0 // indirect
+	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842 // indirect
+	golang.org/x/lint v0.0.0-20210508222113-6edffad5e616 // indirect
+	golang.org/x/mod v0.17.0 // indirect
+	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
 )
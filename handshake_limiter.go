@@ -0,0 +1,117 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ytl
+
+import (
+	"net"
+	"sync"
+)
+
+// Statistics of pending handshakes of YggListener
+type PendingHandshakesStats struct {
+	// Count of connections waiting for handshake pkg now
+	Pending int
+	// Count of connections dropped because of limit per listener
+	DroppedByListenerLimit uint64
+	// Count of connections dropped because of limit per source ip
+	DroppedByIpLimit uint64
+}
+
+// Limits count of connections waiting for handshake pkg
+// to protect listener from slow peers.
+//
+// Only ygg handshake is counted. Transport handshakes (tls, quic, wss)
+// are performed before connection is passed to YggListener,
+// so they are bounded by transport timeouts only.
+type handshakeLimiter struct {
+	mutex       sync.Mutex
+	total       int
+	perIp       int
+	pendingByIp map[string]int
+	stats       PendingHandshakesStats
+}
+
+// Zero limit means no limit
+func newHandshakeLimiter(total, perIp int) *handshakeLimiter {
+	return &handshakeLimiter{
+		total:       total,
+		perIp:       perIp,
+		pendingByIp: make(map[string]int),
+	}
+}
+
+// Returns ip of address as string or empty string
+// if address has no ip (like unix socket).
+func sourceIp(a net.Addr) string {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.IPAddr:
+		return a.IP.String()
+	}
+	return ""
+}
+
+// Registers new pending handshake.
+// Returns false if one of limits is exceeded.
+// Otherwise returns callback that must be called once
+// after handshake is finished.
+func (l *handshakeLimiter) acquire(remote net.Addr) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	ip := sourceIp(remote)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.total > 0 && l.stats.Pending >= l.total {
+		l.stats.DroppedByListenerLimit += 1
+		return nil, false
+	}
+	if l.perIp > 0 && ip != "" && l.pendingByIp[ip] >= l.perIp {
+		l.stats.DroppedByIpLimit += 1
+		return nil, false
+	}
+	l.stats.Pending += 1
+	if ip != "" {
+		l.pendingByIp[ip] += 1
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			l.stats.Pending -= 1
+			if ip != "" {
+				l.pendingByIp[ip] -= 1
+				if l.pendingByIp[ip] <= 0 {
+					delete(l.pendingByIp, ip)
+				}
+			}
+		})
+	}, true
+}
+
+func (l *handshakeLimiter) getStats() PendingHandshakesStats {
+	if l == nil {
+		return PendingHandshakesStats{}
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.stats
+}
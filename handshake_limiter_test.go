@@ -0,0 +1,145 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ytl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHandshakeLimiter(t *testing.T) {
+	limiter := newHandshakeLimiter(3, 2)
+	a := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1}
+	b := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1}
+	unix := &net.UnixAddr{Name: "@a", Net: "unix"}
+	releaseA1, ok := limiter.acquire(a)
+	if !ok {
+		t.Fatalf("First connection must be accepted")
+	}
+	if _, ok := limiter.acquire(a); !ok {
+		t.Fatalf("Second connection must be accepted")
+	}
+	if _, ok := limiter.acquire(a); ok {
+		t.Fatalf("Limit per ip is exceeded")
+	}
+	if _, ok := limiter.acquire(unix); !ok {
+		t.Fatalf("Limit per ip must not be applied to non ip addresses")
+	}
+	if _, ok := limiter.acquire(b); ok {
+		t.Fatalf("Total limit is exceeded")
+	}
+	releaseA1()
+	releaseA1()
+	if _, ok := limiter.acquire(b); !ok {
+		t.Fatalf("Released slot must be available")
+	}
+	stats := limiter.getStats()
+	if stats.Pending != 3 || stats.DroppedByIpLimit != 1 || stats.DroppedByListenerLimit != 1 {
+		t.Fatalf("Wrong stats %+v", stats)
+	}
+	var empty *handshakeLimiter = nil
+	if _, ok := empty.acquire(a); !ok {
+		t.Fatalf("Nil limiter must accept everything")
+	}
+}
+
+func TestYggListenerPendingHandshakesLimit(t *testing.T) {
+	manager := New(context.Background(), WithSendHandshake(true))
+	defer manager.Close()
+	luri, _ := url.Parse("tcp://127.0.0.1:0")
+	listener, err := manager.Listen(*luri)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listener.Close()
+	listener.SetPendingHandshakesLimit(1, 0)
+	slow, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer slow.Close()
+	if _, err := listener.Accept(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	accepted := make(chan YggConn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	excess, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer excess.Close()
+	excess.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := excess.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Excess connection must be closed: %s", err)
+	}
+	if stats := listener.PendingHandshakes(); stats.DroppedByListenerLimit != 1 {
+		t.Fatalf("Wrong stats %+v", stats)
+	}
+	// Slot is released after failed handshake
+	slow.Close()
+	for i := 0; i < 100 && listener.PendingHandshakes().Pending > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	uri, _ := url.Parse(fmt.Sprintf("tcp://%s", listener.Addr().String()))
+	out, err := manager.Connect(*uri)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer out.Close()
+	select {
+	case in := <-accepted:
+		in.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Connection was not accepted")
+	}
+}
+
+func TestYggListenerHandshakeTimeout(t *testing.T) {
+	manager := New(context.Background(), WithSendHandshake(true))
+	defer manager.Close()
+	luri, _ := url.Parse("tcp://127.0.0.1:0")
+	listener, err := manager.Listen(*luri)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listener.Close()
+	listener.SetHandshakeTimeout(100 * time.Millisecond)
+	silent, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer silent.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	select {
+	case <-conn.Closed():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Handshake timeout was not applied")
+	}
+}
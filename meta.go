@@ -0,0 +1,297 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ytl
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"golang.org/x/crypto/blake2b"
+	"io"
+)
+
+// Types of TLV fields of handshake pkg.
+// Same as in yggdrasil-go, so ordering must not be changed.
+const (
+	metaVersionMajor uint16 = iota
+	metaVersionMinor
+	metaPublicKey
+	metaPriority
+)
+
+// Size of header & length (or version for fixed-size pkg) fields
+var metaHeaderSize = len(static.META_HEADER()) + 2
+
+// Size of header followed by type and length of the first TLV field.
+// Pkgs of both layouts are not shorter, so it is read before choosing codec.
+var metaPeekSize = metaHeaderSize + 4
+
+// Returns true if buf starts with type and length of known TLV field
+func isMetaField(buf []byte) bool {
+	lengths := map[uint16]int{
+		metaVersionMajor: 2,
+		metaVersionMinor: 2,
+		metaPublicKey:    ed25519.PublicKeySize,
+		metaPriority:     1,
+	}
+	if len(buf) < 4 {
+		return false
+	}
+	length, ok := lengths[binary.BigEndian.Uint16(buf[:2])]
+	return ok && length == int(binary.BigEndian.Uint16(buf[2:4]))
+}
+
+// Fields of received handshake pkg
+type metaPackage struct {
+	version static.ProtoVersion
+	// May be nil if pkg does not contain valid key
+	pkey ed25519.PublicKey
+	// May be nil if pkg layout does not support signatures
+	sig []byte
+	// Whole pkg as it was received
+	raw []byte
+}
+
+// Handshake pkg codec of certain protocol version
+type metaCodec interface {
+	// Returns protocol version handled by codec.
+	version() static.ProtoVersion
+	// Returns true if pkg starting with header (metaPeekSize bytes) has layout of codec.
+	match(header []byte) bool
+	// Returns handshake pkg with meta info.
+	build(key ed25519.PrivateKey, password []byte, priority uint8) ([]byte, error)
+	// Reads rest of handshake pkg after header (metaPeekSize bytes).
+	read(conn io.Reader, header []byte) (*metaPackage, error)
+	// Checks that pkg was signed with password.
+	verify(pkg *metaPackage, password []byte) error
+}
+
+// Returns all supported codecs from oldest to newest.
+func metaCodecs() []metaCodec {
+	return []metaCodec{
+		legacyMetaCodec{},
+		tlvMetaCodec{},
+	}
+}
+
+// Returns codec for protocol version or nil if it is unsupported.
+func metaCodecForVersion(version static.ProtoVersion) metaCodec {
+	for _, codec := range metaCodecs() {
+		if codec.version() == version {
+			return codec
+		}
+	}
+	return nil
+}
+
+// Returns codec that matches pkg header.
+// Header of any pkg matches to one of codecs.
+func metaCodecForHeader(header []byte) metaCodec {
+	codecs := metaCodecs()
+	for _, codec := range codecs {
+		if codec.match(header) {
+			return codec
+		}
+	}
+	return codecs[0]
+}
+
+// Returns all protocol versions supported by ytl
+// from oldest to newest.
+func SupportedProtoVersions() []static.ProtoVersion {
+	codecs := metaCodecs()
+	versions := make([]static.ProtoVersion, 0, len(codecs))
+	for _, codec := range codecs {
+		versions = append(versions, codec.version())
+	}
+	return versions
+}
+
+// Returns supported protocol versions
+// between min and max (inclusive) from oldest to newest.
+func ProtoVersionsRange(min, max static.ProtoVersion) []static.ProtoVersion {
+	versions := make([]static.ProtoVersion, 0)
+	for _, version := range SupportedProtoVersions() {
+		if !version.Less(min) && !max.Less(version) {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// Returns BLAKE2b hash of public key keyed by password.
+// This hash is signed in TLV handshake pkg.
+func metaPasswordHash(pkey ed25519.PublicKey, password []byte) ([]byte, error) {
+	hasher, err := blake2b.New512(password)
+	if err != nil {
+		return nil, static.InvalidUriError{Err: "password is too long"}
+	}
+	hasher.Write(pkey)
+	return hasher.Sum(nil), nil
+}
+
+func appendMetaField(buf []byte, op uint16, value []byte) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, op)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	return append(buf, value...)
+}
+
+// Codec of fixed-size handshake pkg used before yggdrasil 0.5
+type legacyMetaCodec struct{}
+
+func (c legacyMetaCodec) version() static.ProtoVersion {
+	return static.PROTO_VERSION()
+}
+
+// Header is followed by major and minor version.
+// Legacy versions are always 0.x with small x,
+// so they never look like length of TLV pkg
+// that is always longer than signature.
+// Other versions are legacy too unless the first TLV field follows them,
+// so pkgs of unknown legacy versions are rejected without waiting for the rest.
+func (c legacyMetaCodec) match(header []byte) bool {
+	version := header[len(static.META_HEADER()):metaHeaderSize]
+	if version[0] == 0 && int(version[1]) < ed25519.SignatureSize {
+		return true
+	}
+	return !isMetaField(header[metaHeaderSize:])
+}
+
+func (c legacyMetaCodec) build(key ed25519.PrivateKey, password []byte, priority uint8) ([]byte, error) {
+	version := c.version()
+	buf := make([]byte, 0, metaHeaderSize+ed25519.PublicKeySize)
+	buf = append(buf, static.META_HEADER()...)
+	buf = append(buf, version.Major, version.Minor)
+	return append(buf, key.Public().(ed25519.PublicKey)...), nil
+}
+
+func (c legacyMetaCodec) read(conn io.Reader, header []byte) (*metaPackage, error) {
+	buf := make([]byte, metaHeaderSize+ed25519.PublicKeySize)
+	copy(buf, header)
+	if _, err := io.ReadFull(conn, buf[len(header):]); err != nil {
+		return nil, err
+	}
+	pkey := make(ed25519.PublicKey, ed25519.PublicKeySize)
+	copy(pkey, buf[metaHeaderSize:])
+	return &metaPackage{
+		version: static.ProtoVersion{
+			Major: buf[len(static.META_HEADER())],
+			Minor: buf[len(static.META_HEADER())+1],
+		},
+		pkey: pkey,
+		raw:  buf,
+	}, nil
+}
+
+// Fixed-size pkg can not prove the password
+func (c legacyMetaCodec) verify(pkg *metaPackage, password []byte) error {
+	if len(password) > 0 {
+		return static.IncorrectPasswordError{}
+	}
+	return nil
+}
+
+// Codec of TLV handshake pkg used since yggdrasil 0.5
+type tlvMetaCodec struct{}
+
+func (c tlvMetaCodec) version() static.ProtoVersion {
+	return static.PROTO_VERSION_TLV()
+}
+
+// Header is followed by big endian length of the rest.
+// Any header that is not legacy one is TLV.
+func (c tlvMetaCodec) match(header []byte) bool {
+	return !(legacyMetaCodec{}).match(header)
+}
+
+func (c tlvMetaCodec) build(key ed25519.PrivateKey, password []byte, priority uint8) ([]byte, error) {
+	version := c.version()
+	pkey := key.Public().(ed25519.PublicKey)
+	buf := make([]byte, 0, 128)
+	buf = append(buf, static.META_HEADER()...)
+	buf = append(buf, 0, 0) // Length of the rest
+	buf = appendMetaField(buf, metaVersionMajor, binary.BigEndian.AppendUint16(nil, uint16(version.Major)))
+	buf = appendMetaField(buf, metaVersionMinor, binary.BigEndian.AppendUint16(nil, uint16(version.Minor)))
+	buf = appendMetaField(buf, metaPublicKey, pkey)
+	buf = appendMetaField(buf, metaPriority, []byte{priority})
+	hash, err := metaPasswordHash(pkey, password)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, ed25519.Sign(key, hash)...)
+	binary.BigEndian.PutUint16(buf[len(static.META_HEADER()):], uint16(len(buf)-metaHeaderSize))
+	return buf, nil
+}
+
+// Unknown fields are skipped.
+func (c tlvMetaCodec) read(conn io.Reader, header []byte) (*metaPackage, error) {
+	length := int(binary.BigEndian.Uint16(header[len(static.META_HEADER()):]))
+	buf := make([]byte, metaHeaderSize+length)
+	copy(buf, header)
+	if _, err := io.ReadFull(conn, buf[len(header):]); err != nil {
+		return nil, err
+	}
+	pkg := &metaPackage{
+		sig: buf[len(buf)-ed25519.SignatureSize:],
+		raw: buf,
+	}
+	fields := buf[metaHeaderSize : len(buf)-ed25519.SignatureSize]
+	for len(fields) >= 4 {
+		op := binary.BigEndian.Uint16(fields[:2])
+		oplen := int(binary.BigEndian.Uint16(fields[2:4]))
+		fields = fields[4:]
+		if len(fields) < oplen {
+			break
+		}
+		value := fields[:oplen]
+		fields = fields[oplen:]
+		switch op {
+		case metaVersionMajor:
+			if len(value) == 2 {
+				pkg.version.Major = uint8(binary.BigEndian.Uint16(value))
+			}
+		case metaVersionMinor:
+			if len(value) == 2 {
+				pkg.version.Minor = uint8(binary.BigEndian.Uint16(value))
+			}
+		case metaPublicKey:
+			if len(value) == ed25519.PublicKeySize {
+				pkg.pkey = make(ed25519.PublicKey, ed25519.PublicKeySize)
+				copy(pkg.pkey, value)
+			}
+		}
+	}
+	return pkg, nil
+}
+
+// Wrong password is indistinguishable from broken signature
+func (c tlvMetaCodec) verify(pkg *metaPackage, password []byte) error {
+	if pkg.pkey == nil {
+		return static.IvalidPeerPublicKey{Text: "Handshake pkg does not contain public key"}
+	}
+	hash, err := metaPasswordHash(pkg.pkey, password)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pkg.pkey, hash, pkg.sig) {
+		if len(password) > 0 {
+			return static.IncorrectPasswordError{}
+		}
+		return static.InvalidHandshakeSignatureError{}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ytl
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"net"
+	"testing"
+)
+
+func parseBuiltMetaPackage(buf []byte, password []byte) (error, *static.ProtoVersion, ed25519.PublicKey, []byte) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	go a.Write(buf)
+	return internalParseMetaPackage(b, &HandshakeConfig{Password: password})
+}
+
+func TestMetaPackageTLV(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	tlv := static.PROTO_VERSION_TLV()
+	for _, password := range [][]byte{nil, []byte("password")} {
+		pkg, err := tlvMetaCodec{}.build(priv, password, 1)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if metaCodecForHeader(pkg[:metaPeekSize]) != (tlvMetaCodec{}) {
+			t.Fatalf("Pkg must have TLV layout")
+		}
+		err, version, pkey, buf := parseBuiltMetaPackage(pkg, password)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if *version != tlv {
+			t.Errorf("Wrong version %s", version)
+		}
+		if bytes.Compare(pkey, pub) != 0 {
+			t.Errorf("Wrong public key")
+		}
+		if bytes.Compare(buf, pkg) != 0 {
+			t.Errorf("Wrong buf")
+		}
+	}
+}
+
+func TestMetaPackageTLVPassword(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	pkg, _ := tlvMetaCodec{}.build(priv, []byte("password"), 0)
+	err, _, pkey, _ := parseBuiltMetaPackage(pkg, []byte("other"))
+	if _, ok := err.(static.IncorrectPasswordError); !ok {
+		t.Errorf("Must raise IncorrectPasswordError: %s", err)
+	}
+	if pkey != nil {
+		t.Errorf("Key must not be returned")
+	}
+	err, _, _, _ = parseBuiltMetaPackage(pkg, nil)
+	if _, ok := err.(static.InvalidHandshakeSignatureError); !ok {
+		t.Errorf("Must raise InvalidHandshakeSignatureError: %s", err)
+	}
+	// Fixed-size pkg can not be signed
+	pkg, _ = legacyMetaCodec{}.build(priv, nil, 0)
+	err, _, _, _ = parseBuiltMetaPackage(pkg, []byte("password"))
+	if _, ok := err.(static.IncorrectPasswordError); !ok {
+		t.Errorf("Must raise IncorrectPasswordError: %s", err)
+	}
+	// Too long password
+	_, err = tlvMetaCodec{}.build(priv, make([]byte, 65), 0)
+	if err == nil {
+		t.Errorf("Must raise error")
+	}
+}
+
+func TestMetaPackageTLVBrokenSignature(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	pkg, _ := tlvMetaCodec{}.build(priv, nil, 0)
+	pkg[len(pkg)-1] ^= 0xff
+	err, _, _, _ := parseBuiltMetaPackage(pkg, nil)
+	if _, ok := err.(static.InvalidHandshakeSignatureError); !ok {
+		t.Errorf("Must raise InvalidHandshakeSignatureError: %s", err)
+	}
+}
+
+// Pkg longer than 255 bytes (e.g. with unknown fields)
+// must not be parsed as legacy one
+func TestMetaPackageTLVLong(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	pkg := append([]byte{}, static.META_HEADER()...)
+	pkg = append(pkg, 0, 0)
+	pkg = appendMetaField(pkg, metaVersionMajor, []byte{0, 0})
+	pkg = appendMetaField(pkg, metaVersionMinor, []byte{0, 5})
+	pkg = appendMetaField(pkg, metaPublicKey, pub)
+	pkg = appendMetaField(pkg, 0xff, make([]byte, 256))
+	hash, _ := metaPasswordHash(pub, nil)
+	pkg = append(pkg, ed25519.Sign(priv, hash)...)
+	binary.BigEndian.PutUint16(pkg[len(static.META_HEADER()):], uint16(len(pkg)-metaHeaderSize))
+	if pkg[len(static.META_HEADER())] == 0 {
+		t.Fatalf("Length of pkg must not fit in one byte")
+	}
+	if metaCodecForHeader(pkg[:metaPeekSize]) != (tlvMetaCodec{}) {
+		t.Fatalf("Pkg must have TLV layout")
+	}
+	err, version, pkey, _ := parseBuiltMetaPackage(pkg, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if *version != static.PROTO_VERSION_TLV() || !bytes.Equal(pkey, pub) {
+		t.Errorf("Wrong version or key: %s %x", version, pkey)
+	}
+	legacy, _ := legacyMetaCodec{}.build(priv, nil, 0)
+	if metaCodecForHeader(legacy[:metaPeekSize]) != (legacyMetaCodec{}) {
+		t.Errorf("Pkg must have legacy layout")
+	}
+}
+
+func TestMetaPackageTLVWithoutKey(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	pkg := append([]byte{}, static.META_HEADER()...)
+	pkg = append(pkg, 0, 0)
+	pkg = appendMetaField(pkg, metaVersionMajor, []byte{0, 0})
+	pkg = appendMetaField(pkg, metaVersionMinor, []byte{0, 5})
+	pkg = append(pkg, ed25519.Sign(priv, []byte{})...)
+	pkg[len(static.META_HEADER())+1] = byte(len(pkg) - metaHeaderSize)
+	err, _, _, _ := parseBuiltMetaPackage(pkg, nil)
+	if _, ok := err.(static.IvalidPeerPublicKey); !ok {
+		t.Errorf("Must raise IvalidPeerPublicKey: %s", err)
+	}
+}
+
+// Testing that nodes with passwords are able to connect to each other
+// only if passwords are the same
+func TestYggConnHandshakeTLVPassword(t *testing.T) {
+	tlv := static.PROTO_VERSION_TLV()
+	for _, c := range []struct {
+		passA, passB string
+		ok           bool
+	}{
+		{"", "", true},
+		{"secret", "secret", true},
+		{"secret", "other", false},
+		{"", "secret", false},
+	} {
+		_, apriv, _ := ed25519.GenerateKey(nil)
+		_, bpriv, _ := ed25519.GenerateKey(nil)
+		a, b := net.Pipe()
+		yggconA := ConnToYggConnWithHandshake(a, nil, nil, 0, nil, &HandshakeConfig{
+			Key: apriv, Version: &tlv, Password: []byte(c.passA),
+		})
+		yggconB := ConnToYggConnWithHandshake(b, nil, nil, 0, nil, &HandshakeConfig{
+			Key: bpriv, Version: &tlv, Password: []byte(c.passB),
+		})
+		_, errA := yggconA.GetPublicKey()
+		_, errB := yggconB.GetPublicKey()
+		if c.ok && (errA != nil || errB != nil) {
+			t.Errorf("Unexpected error: %s %s", errA, errB)
+		}
+		if !c.ok && (errA == nil || errB == nil) {
+			t.Errorf("Connection with wrong password must be rejected")
+		}
+		yggconA.Close()
+		yggconB.Close()
+	}
+}
+
+func TestProtoVersionsRange(t *testing.T) {
+	legacy := static.PROTO_VERSION()
+	tlv := static.PROTO_VERSION_TLV()
+	cases := []struct {
+		min, max static.ProtoVersion
+		res      []static.ProtoVersion
+	}{
+		{static.ProtoVersion{}, static.ProtoVersion{Major: 255, Minor: 255}, SupportedProtoVersions()},
+		{legacy, legacy, []static.ProtoVersion{legacy}},
+		{tlv, static.ProtoVersion{Major: 1}, []static.ProtoVersion{tlv}},
+		{static.ProtoVersion{Major: 1}, static.ProtoVersion{Major: 2}, []static.ProtoVersion{}},
+	}
+	for _, c := range cases {
+		res := ProtoVersionsRange(c.min, c.max)
+		if len(res) != len(c.res) {
+			t.Errorf("Wrong range %s - %s: %v", c.min, c.max, res)
+			continue
+		}
+		for i := range res {
+			if res[i] != c.res[i] {
+				t.Errorf("Wrong range %s - %s: %v", c.min, c.max, res)
+			}
+		}
+	}
+}
+
+// Testing that responder replies with version of connecting node
+// if it is accepted
+func TestYggConnVersionNegotiation(t *testing.T) {
+	legacy := static.PROTO_VERSION()
+	tlv := static.PROTO_VERSION_TLV()
+	cases := []struct {
+		sent     static.ProtoVersion
+		accepted []static.ProtoVersion
+		ok       bool
+	}{
+		{legacy, nil, true},
+		{tlv, nil, true},
+		{legacy, []static.ProtoVersion{tlv}, false},
+		{tlv, []static.ProtoVersion{legacy}, false},
+		{tlv, []static.ProtoVersion{tlv, legacy}, true},
+	}
+	for _, c := range cases {
+		_, apriv, _ := ed25519.GenerateKey(nil)
+		_, bpriv, _ := ed25519.GenerateKey(nil)
+		a, b := net.Pipe()
+		sent := c.sent
+		initiator := ConnToYggConnWithHandshake(a, nil, nil, 0, nil, &HandshakeConfig{
+			Key: apriv, Version: &sent,
+		})
+		responder := ConnToYggConnWithHandshake(b, nil, nil, 0, nil, &HandshakeConfig{
+			Key: bpriv, Versions: c.accepted, Responder: true,
+		})
+		rversion, rerr := responder.GetVer()
+		if !c.ok {
+			if rerr == nil {
+				t.Errorf("Version %s must be rejected by %v", c.sent, c.accepted)
+			}
+			initiator.Close()
+			responder.Close()
+			continue
+		}
+		iversion, ierr := initiator.GetVer()
+		if ierr != nil || rerr != nil {
+			t.Errorf("Unexpected error: %s %s", ierr, rerr)
+		} else if *iversion != c.sent || *rversion != c.sent {
+			t.Errorf("Wrong negotiated version %s %s %s", c.sent, iversion, rversion)
+		}
+		initiator.Close()
+		responder.Close()
+	}
+}
+
+// Initiator must reject reply with other version
+func TestYggConnVersionMismatch(t *testing.T) {
+	legacy := static.PROTO_VERSION()
+	tlv := static.PROTO_VERSION_TLV()
+	_, apriv, _ := ed25519.GenerateKey(nil)
+	_, bpriv, _ := ed25519.GenerateKey(nil)
+	a, b := net.Pipe()
+	yggconA := ConnToYggConnWithHandshake(a, nil, nil, 0, nil, &HandshakeConfig{Key: apriv, Version: &legacy})
+	yggconB := ConnToYggConnWithHandshake(b, nil, nil, 0, nil, &HandshakeConfig{Key: bpriv, Version: &tlv})
+	defer yggconA.Close()
+	defer yggconB.Close()
+	// Side that detects mismatch first closes connection,
+	// so other one may get EOF instead
+	_, errA := yggconA.GetPublicKey()
+	_, errB := yggconB.GetPublicKey()
+	if errA == nil || errB == nil {
+		t.Fatalf("Must raise error: %v, %v", errA, errB)
+	}
+	_, okA := errA.(static.UnknownProtoVersionError)
+	_, okB := errB.(static.UnknownProtoVersionError)
+	if !okA && !okB {
+		t.Errorf("Must raise UnknownProtoVersionError: %s, %s", errA, errB)
+	}
+}
@@ -0,0 +1,262 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package metrics
+
+import (
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Returns short name of error type used as label value
+func errorType(err error) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", err), "*")
+}
+
+// Returns reason of connection rejection used as label value
+func rejectReason(err error) string {
+	switch err.(type) {
+	case static.IvalidPeerPublicKey:
+		return "allow_list"
+	case static.ConnClosedByDeduplicatorError:
+		return "deduplicator"
+	case static.TransportSecurityCheckError:
+		return "transport_key"
+	case static.UnacceptableAddressError:
+		return "address"
+	default:
+		return "handshake"
+	}
+}
+
+type dialFailure struct {
+	scheme    string
+	errorType string
+}
+
+type traffic struct {
+	bytesIn    uint64
+	bytesOut   uint64
+	packetsIn  uint64
+	packetsOut uint64
+}
+
+func (t *traffic) add(info ytl.ConnSnapshot) {
+	t.bytesIn += info.BytesIn
+	t.bytesOut += info.BytesOut
+	t.packetsIn += info.PacketsIn
+	t.packetsOut += info.PacketsOut
+}
+
+// Collects metrics of ConnManager.
+//
+// It implements ytl.Observer,
+// so it must be set as manager observer to receive events.
+// Active connections and traffic are taken from manager directly.
+type Collector struct {
+	manager          *ytl.ConnManager
+	mutex            sync.Mutex
+	dialAttempts     map[string]uint64
+	dialFailures     map[dialFailure]uint64
+	rejections       map[string]uint64
+	evicted          uint64
+	handshakes       uint64
+	handshakeLatency float64
+	closed           uint64
+	closedTraffic    traffic
+}
+
+// Create new Collector.
+//
+// Manager can be nil,
+// then active connections are not reported.
+func NewCollector(manager *ytl.ConnManager) *Collector {
+	return &Collector{
+		manager:      manager,
+		dialAttempts: make(map[string]uint64),
+		dialFailures: make(map[dialFailure]uint64),
+		rejections:   make(map[string]uint64),
+	}
+}
+
+func (c *Collector) OnDial(uri url.URL, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.dialAttempts[uri.Scheme] += 1
+	if err != nil {
+		c.dialFailures[dialFailure{uri.Scheme, errorType(err)}] += 1
+	}
+}
+
+func (c *Collector) OnAccept(info ytl.ConnSnapshot) {}
+
+func (c *Collector) OnHandshake(info ytl.ConnSnapshot) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.handshakes += 1
+	c.handshakeLatency += info.HandshakeLatency.Seconds()
+}
+
+func (c *Collector) OnReject(info ytl.ConnSnapshot, reason error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.rejections[rejectReason(reason)] += 1
+}
+
+func (c *Collector) OnDuplicateEvicted(info ytl.ConnSnapshot) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.evicted += 1
+}
+
+func (c *Collector) OnClose(info ytl.ConnSnapshot) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed += 1
+	c.closedTraffic.add(info)
+}
+
+// Returns current values of all metrics
+func (c *Collector) Metrics() []Metric {
+	var active []ytl.ConnSnapshot = nil
+	total := traffic{}
+	if c.manager != nil {
+		active = c.manager.Connections()
+		// Closed connections must not be lost or counted twice
+		// between OnClose and removing from manager
+		t := c.manager.Traffic()
+		total = traffic{t.BytesIn, t.BytesOut, t.PacketsIn, t.PacketsOut}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.manager == nil {
+		total = c.closedTraffic
+	}
+	dialAttempts := Metric{
+		Name: "ytl_dial_attempts_total",
+		Help: "Count of outgoing connection attempts.",
+		Type: "counter",
+	}
+	for scheme, count := range c.dialAttempts {
+		dialAttempts.Samples = append(dialAttempts.Samples, Sample{
+			Labels: []Label{{"scheme", scheme}},
+			Value:  float64(count),
+		})
+	}
+	dialFailures := Metric{
+		Name: "ytl_dial_failures_total",
+		Help: "Count of failed outgoing connection attempts.",
+		Type: "counter",
+	}
+	for failure, count := range c.dialFailures {
+		dialFailures.Samples = append(dialFailures.Samples, Sample{
+			Labels: []Label{{"scheme", failure.scheme}, {"error", failure.errorType}},
+			Value:  float64(count),
+		})
+	}
+	rejections := Metric{
+		Name: "ytl_rejections_total",
+		Help: "Count of rejected connections.",
+		Type: "counter",
+	}
+	for reason, count := range c.rejections {
+		rejections.Samples = append(rejections.Samples, Sample{
+			Labels: []Label{{"reason", reason}},
+			Value:  float64(count),
+		})
+	}
+	activeCount := make(map[uint]uint64)
+	for _, info := range active {
+		activeCount[info.SecurityLevel] += 1
+	}
+	activeConns := Metric{
+		Name: "ytl_active_connections",
+		Help: "Count of active connections.",
+		Type: "gauge",
+	}
+	for level, count := range activeCount {
+		activeConns.Samples = append(activeConns.Samples, Sample{
+			Labels: []Label{{"security_level", strconv.FormatUint(uint64(level), 10)}},
+			Value:  float64(count),
+		})
+	}
+	return []Metric{
+		dialAttempts,
+		dialFailures,
+		rejections,
+		{
+			Name:    "ytl_duplicates_evicted_total",
+			Help:    "Count of connections closed because of more secure duplicate.",
+			Type:    "counter",
+			Samples: []Sample{{Value: float64(c.evicted)}},
+		},
+		{
+			Name: "ytl_handshake_latency_seconds",
+			Help: "Time from start of connecting to parsed handshake pkg.",
+			Type: "summary",
+			Samples: []Sample{
+				{Suffix: "_sum", Value: c.handshakeLatency},
+				{Suffix: "_count", Value: float64(c.handshakes)},
+			},
+		},
+		activeConns,
+		{
+			Name:    "ytl_closed_connections_total",
+			Help:    "Count of closed connections.",
+			Type:    "counter",
+			Samples: []Sample{{Value: float64(c.closed)}},
+		},
+		{
+			Name: "ytl_transferred_bytes_total",
+			Help: "Count of bytes transferred by all connections.",
+			Type: "counter",
+			Samples: []Sample{
+				{Labels: []Label{{"direction", "in"}}, Value: float64(total.bytesIn)},
+				{Labels: []Label{{"direction", "out"}}, Value: float64(total.bytesOut)},
+			},
+		},
+		{
+			Name: "ytl_transferred_packets_total",
+			Help: "Count of packets transferred by all connections.",
+			Type: "counter",
+			Samples: []Sample{
+				{Labels: []Label{{"direction", "in"}}, Value: float64(total.packetsIn)},
+				{Labels: []Label{{"direction", "out"}}, Value: float64(total.packetsOut)},
+			},
+		},
+	}
+}
+
+// Writes all metrics in Prometheus text exposition format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	return WriteText(w, c.Metrics())
+}
+
+// Returns http handler that serves metrics
+// in Prometheus text exposition format.
+func (c *Collector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.WriteTo(w)
+	})
+}
@@ -0,0 +1,106 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRejectReason(t *testing.T) {
+	cases := map[string]error{
+		"allow_list":    static.IvalidPeerPublicKey{},
+		"deduplicator":  static.ConnClosedByDeduplicatorError{},
+		"transport_key": static.TransportSecurityCheckError{},
+		"address":       static.UnacceptableAddressError{},
+		"handshake":     io.EOF,
+	}
+	for reason, err := range cases {
+		if r := rejectReason(err); r != reason {
+			t.Errorf("Wrong reason %s for %s", r, err)
+		}
+	}
+}
+
+func TestCollector(t *testing.T) {
+	manager := ytl.NewConnManager(context.Background(), nil, nil, nil, nil)
+	defer manager.Close()
+	manager.SetSendHandshake(true)
+	collector := NewCollector(manager)
+	manager.SetObserver(collector)
+	luri, _ := url.Parse("tcp://127.0.0.1:0")
+	listener, err := manager.Listen(*luri)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	accepted := make(chan ytl.YggConn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	uri, _ := url.Parse(fmt.Sprintf("tcp://%s", listener.Addr().String()))
+	out, err := manager.Connect(*uri)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	in := <-accepted
+	if _, err := out.Write([]byte{1, 2, 3}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := io.ReadFull(&in, make([]byte, 3)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	out.GetPublicKey()
+	broken, _ := url.Parse("tcp://[200::1]:1")
+	manager.Connect(*broken)
+	refused, _ := url.Parse(fmt.Sprintf("tcp://%s?key=%s", listener.Addr().String(), strings.Repeat("00", 32)))
+	manager.Connect(*refused)
+	server := httptest.NewServer(collector.Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	text := string(body)
+	for _, line := range []string{
+		`ytl_dial_attempts_total{scheme="tcp"} 3`,
+		`ytl_rejections_total{reason="allow_list"} 1`,
+		`ytl_dial_failures_total{scheme="tcp",error="static.UnacceptableAddressError"} 1`,
+		`ytl_active_connections{security_level="0"} 2`,
+		`ytl_transferred_packets_total{direction="in"} 1`,
+		`ytl_transferred_packets_total{direction="out"} 1`,
+		`ytl_handshake_latency_seconds_count 2`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("Line '%s' is not found in output:\n%s", line, text)
+		}
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Wrong content type")
+	}
+}
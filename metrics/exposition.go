@@ -0,0 +1,103 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+// Package metrics collects statistics of ytl connections
+// and renders it in Prometheus text exposition format
+// without any external dependency.
+//
+//	collector := metrics.NewCollector(manager)
+//	manager.SetObserver(collector)
+//	http.Handle("/metrics", collector.Handler())
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Label of metric sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Single value of metric
+type Sample struct {
+	// Suffix of metric name like "_sum", usually empty
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// Metric family with all its samples
+type Metric struct {
+	Name string
+	Help string
+	// "counter", "gauge", "summary" or "untyped"
+	Type    string
+	Samples []Sample
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for _, label := range labels {
+		parts = append(parts, fmt.Sprintf(
+			`%s="%s"`, label.Name, labelValueEscaper.Replace(label.Value),
+		))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Writes metrics in Prometheus text exposition format.
+// Samples of each metric are sorted by labels,
+// so output is stable.
+func WriteText(w io.Writer, metrics []Metric) (int64, error) {
+	var b strings.Builder
+	for _, metric := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n", metric.Name, helpEscaper.Replace(metric.Help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", metric.Name, metric.Type)
+		lines := make([]string, 0, len(metric.Samples))
+		for _, sample := range metric.Samples {
+			lines = append(lines, fmt.Sprintf(
+				"%s%s%s %s\n",
+				metric.Name,
+				sample.Suffix,
+				formatLabels(sample.Labels),
+				formatValue(sample.Value),
+			))
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			b.WriteString(line)
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
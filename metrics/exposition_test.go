@@ -0,0 +1,57 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	var b strings.Builder
+	_, err := WriteText(&b, []Metric{
+		{
+			Name: "a_total",
+			Help: "Help\nwith newline",
+			Type: "counter",
+			Samples: []Sample{
+				{Labels: []Label{{"x", "2"}}, Value: 2},
+				{Labels: []Label{{"x", "1\"\\\n"}}, Value: 1.5},
+			},
+		},
+		{
+			Name:    "b",
+			Help:    "Summary",
+			Type:    "summary",
+			Samples: []Sample{{Suffix: "_sum", Value: 0.25}, {Suffix: "_count", Value: 3}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	correct := "# HELP a_total Help\\nwith newline\n" +
+		"# TYPE a_total counter\n" +
+		"a_total{x=\"1\\\"\\\\\\n\"} 1.5\n" +
+		"a_total{x=\"2\"} 2\n" +
+		"# HELP b Summary\n" +
+		"# TYPE b summary\n" +
+		"b_count 3\n" +
+		"b_sum 0.25\n"
+	if b.String() != correct {
+		t.Fatalf("Wrong output:\n%s\nExpected:\n%s", b.String(), correct)
+	}
+}
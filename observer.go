@@ -0,0 +1,65 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ytl
+
+import (
+	"net/url"
+)
+
+// Receives events of connections lifecycle.
+//
+// Methods are called synchronously from goroutines
+// serving connections, so they must not block.
+// Embed NoopObserver to implement only some of them.
+type Observer interface {
+	// Called after outgoing transport connection attempt.
+	// Err is nil if transport connection was opened.
+	// Secrets of uri are masked (see static.RedactURL).
+	OnDial(uri url.URL, err error)
+	// Called after incoming transport connection was accepted.
+	OnAccept(info ConnSnapshot)
+	// Called after handshake pkg was received and connection passed
+	// transport key, allow list and deduplication checks.
+	OnHandshake(info ConnSnapshot)
+	// Called when connection is rejected
+	// because of broken handshake, transport key or allow list mismatch
+	// or duplication.
+	// Reason is the error returned from connection methods after that.
+	OnReject(info ConnSnapshot, reason error)
+	// Called when established connection is closed by DeduplicationManager
+	// because more secure connection with the same node was opened.
+	OnDuplicateEvicted(info ConnSnapshot)
+	// Called once when connection is closed for any reason,
+	// rejected connections included.
+	// Info contains final counters of transferred bytes.
+	OnClose(info ConnSnapshot)
+}
+
+// Observer that ignores all events
+type NoopObserver struct{}
+
+func (NoopObserver) OnDial(uri url.URL, err error) {}
+
+func (NoopObserver) OnAccept(info ConnSnapshot) {}
+
+func (NoopObserver) OnHandshake(info ConnSnapshot) {}
+
+func (NoopObserver) OnReject(info ConnSnapshot, reason error) {}
+
+func (NoopObserver) OnDuplicateEvicted(info ConnSnapshot) {}
+
+func (NoopObserver) OnClose(info ConnSnapshot) {}
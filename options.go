@@ -0,0 +1,154 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ytl
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"github.com/Yggdrasil-Unofficial/ytl/transports"
+	"log/slog"
+	"time"
+)
+
+// Option of ConnManager passed to New
+type Option func(*ConnManager)
+
+// Create new ConnManager configured with options.
+//
+// Without options manager uses default transports list,
+// random key for each connection, no proxies,
+// no deduplication and no allow list.
+//
+// When ctx is canceled, manager is closed
+// with all its listeners and connections.
+func New(ctx context.Context, options ...Option) *ConnManager {
+	ctx, cancel := context.WithCancel(ctx)
+	manager := &ConnManager{
+		transports:   transportsListToMap(transports.DEFAULT_TRANSPORTS()),
+		proxyManager: NewProxyManager(nil, nil),
+		ctx:          ctx,
+		cancel:       cancel,
+		registry:     newConnRegistry(),
+	}
+	for _, option := range options {
+		option(manager)
+	}
+	manager.proxyManager.StartHealthChecks(ctx, 0)
+	context.AfterFunc(ctx, func() { manager.Close() })
+	return manager
+}
+
+// Sets private key of node.
+// If key is nil, new random key is used for each connection.
+func WithKey(key ed25519.PrivateKey) Option {
+	return func(c *ConnManager) {
+		c.key = key
+	}
+}
+
+// Sets ProxyManager used to select proxy for outgoing connections.
+// Nil means no proxies.
+// Health checks of candidate proxies run until manager is closed.
+func WithProxyManager(proxy *ProxyManager) Option {
+	return func(c *ConnManager) {
+		if proxy == nil {
+			c.proxyManager = NewProxyManager(nil, nil)
+		} else {
+			c.proxyManager = *proxy
+		}
+	}
+}
+
+// Sets DeduplicationManager used to close duplicate connections.
+// Nil disables deduplication.
+func WithDeduplication(dm *DeduplicationManager) Option {
+	return func(c *ConnManager) {
+		c.dm = dm
+	}
+}
+
+// Sets list of nodes allowed to connect.
+// Nil allows all nodes.
+func WithAllowList(allowList *static.AllowList) Option {
+	return func(c *ConnManager) {
+		c.allowList = allowList
+	}
+}
+
+// Replaces default transports list.
+func WithTransports(list []static.Transport) Option {
+	return func(c *ConnManager) {
+		c.transports = transportsListToMap(list)
+	}
+}
+
+// Same as ConnManager.SetHandshakeTimeout
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(c *ConnManager) {
+		c.SetHandshakeTimeout(timeout)
+	}
+}
+
+// Same as ConnManager.SetPendingHandshakesLimit
+func WithPendingHandshakesLimit(total, perIp int) Option {
+	return func(c *ConnManager) {
+		c.SetPendingHandshakesLimit(total, perIp)
+	}
+}
+
+// Same as ConnManager.SetSendHandshake
+func WithSendHandshake(enabled bool) Option {
+	return func(c *ConnManager) {
+		c.SetSendHandshake(enabled)
+	}
+}
+
+// Same as ConnManager.SetProtoVersions
+func WithProtoVersions(versions ...static.ProtoVersion) Option {
+	return func(c *ConnManager) {
+		c.SetProtoVersions(versions)
+	}
+}
+
+// Same as ConnManager.SetObserver
+func WithObserver(observer Observer) Option {
+	return func(c *ConnManager) {
+		c.SetObserver(observer)
+	}
+}
+
+// Same as ConnManager.SetLogger
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *ConnManager) {
+		c.SetLogger(logger)
+	}
+}
+
+// Same as ConnManager.SetResolver
+func WithResolver(resolver static.Resolver) Option {
+	return func(c *ConnManager) {
+		c.SetResolver(resolver)
+	}
+}
+
+// Same as ConnManager.SetBindOptions
+func WithBindOptions(options static.BindOptions) Option {
+	return func(c *ConnManager) {
+		c.SetBindOptions(options)
+	}
+}
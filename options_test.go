@@ -0,0 +1,126 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ytl
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/Yggdrasil-Unofficial/ytl/debugstuff"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewDefaults(t *testing.T) {
+	manager := New(context.Background())
+	defer manager.Close()
+	legacy := NewConnManager(context.Background(), nil, nil, nil, nil)
+	defer legacy.Close()
+	if len(manager.transports) != len(legacy.transports) {
+		t.Fatalf("Default transports must be used")
+	}
+	if manager.key != nil || manager.dm != nil || manager.allowList != nil {
+		t.Fatalf("Unexpected defaults")
+	}
+	if manager.proxyManager.Get(url.URL{Scheme: "tcp", Host: "a:1"}) != nil {
+		t.Fatalf("Proxy must not be used by default")
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	proxy, _ := url.Parse("socks://127.0.0.1:9050")
+	pm := NewProxyManager(proxy, nil)
+	dm := NewDeduplicationManager(true, nil)
+	allowList := static.AllowList{}
+	observer := NoopObserver{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := New(
+		context.Background(),
+		WithKey(key),
+		WithProxyManager(&pm),
+		WithDeduplication(dm),
+		WithAllowList(&allowList),
+		WithTransports([]static.Transport{debugstuff.MockTransport{Scheme: "a"}}),
+		WithHandshakeTimeout(time.Second),
+		WithSendHandshake(true),
+		WithProtoVersions(static.PROTO_VERSION()),
+		WithObserver(observer),
+		WithLogger(logger),
+		WithPendingHandshakesLimit(2, 1),
+		WithResolver(net.DefaultResolver),
+		WithBindOptions(static.BindOptions{Interface: "eth0"}),
+	)
+	defer manager.Close()
+	if manager.key == nil || manager.dm != dm || manager.allowList != &allowList {
+		t.Fatalf("Options were not applied")
+	}
+	if manager.proxyManager.Get(url.URL{Scheme: "tcp", Host: "a:1"}) != proxy {
+		t.Fatalf("Proxy manager was not applied")
+	}
+	if _, ok := manager.transports["a"]; !ok || len(manager.transports) != 1 {
+		t.Fatalf("Transports were not applied")
+	}
+	if manager.handshakeTimeout != time.Second || !manager.sendMeta {
+		t.Fatalf("Handshake options were not applied")
+	}
+	if manager.pendingLimit != 2 || manager.pendingLimitPerIp != 1 {
+		t.Fatalf("Pending handshakes limit was not applied")
+	}
+	if len(manager.versions) != 1 || manager.observer != observer || manager.logger != logger {
+		t.Fatalf("Options were not applied")
+	}
+	if manager.resolver != net.DefaultResolver || manager.bind.Interface != "eth0" {
+		t.Fatalf("Options were not applied")
+	}
+}
+
+func TestNewHandshakeTimeout(t *testing.T) {
+	manager := New(
+		context.Background(),
+		WithSendHandshake(true),
+		WithHandshakeTimeout(100*time.Millisecond),
+	)
+	defer manager.Close()
+	luri, _ := url.Parse("tcp://127.0.0.1:0")
+	listener, err := manager.Listen(*luri)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listener.Close()
+	silent, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer silent.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	select {
+	case <-conn.Closed():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Handshake timeout was not applied")
+	}
+	if key, _ := conn.GetPublicKey(); key != nil {
+		t.Fatalf("Key must not be received")
+	}
+}
@@ -0,0 +1,227 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ytl
+
+import (
+	"context"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Default limit of reconnection delay, the same as in yggdrasil-go
+func DEFAULT_MAX_BACKOFF() time.Duration {
+	return time.Second << 12
+}
+
+// Minimal allowed value of "maxbackoff" uri param
+func MIN_MAX_BACKOFF() time.Duration {
+	return 5 * time.Second
+}
+
+// Reads "maxbackoff" uri param.
+// Returns DEFAULT_MAX_BACKOFF if param is missing or malformed.
+func maxBackoffFromUri(uri url.URL) time.Duration {
+	peer, err := static.PeerURIFromURL(uri)
+	if err != nil || peer.MaxBackoff == 0 {
+		return DEFAULT_MAX_BACKOFF()
+	}
+	duration := peer.MaxBackoff
+	if duration < MIN_MAX_BACKOFF() {
+		return MIN_MAX_BACKOFF()
+	}
+	return duration
+}
+
+// Exponential delay with jitter before reconnection attempt number n.
+// Result is in range [delay/2, delay], where delay is limited by maxBackoff.
+func backoffDelay(n uint, maxBackoff time.Duration) time.Duration {
+	if n > 32 {
+		n = 32
+	}
+	delay := time.Second << n
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// Keeps configured set of peers connected.
+//
+// For each added peer it opens connection with ConnManager
+// and sends it to channel returned by Conns method
+// after handshake pkg is received.
+// When connection is closed or can not be opened,
+// it will be reopened with exponential backoff and jitter.
+// Delay limit may be set with "maxbackoff" uri param, e.g. "?maxbackoff=30s".
+//
+// Supervisor stops when ConnManager context is canceled.
+// All connections opened by supervisor are closed on stop
+// and channel returned by Conns method is closed too.
+type PeerSupervisor struct {
+	manager *ConnManager
+	conns   chan *YggConn
+	peers   map[string]context.CancelFunc
+	mutex   sync.Mutex
+	wg      sync.WaitGroup
+	stopped bool
+}
+
+// Create new PeerSupervisor that uses manager to open connections.
+func NewPeerSupervisor(manager *ConnManager) *PeerSupervisor {
+	s := &PeerSupervisor{
+		manager: manager,
+		conns:   make(chan *YggConn),
+		peers:   make(map[string]context.CancelFunc),
+	}
+	go func() {
+		<-manager.ctx.Done()
+		s.mutex.Lock()
+		s.stopped = true
+		s.mutex.Unlock()
+		s.wg.Wait()
+		close(s.conns)
+	}()
+	return s
+}
+
+// Returns channel with opened connections.
+//
+// Supervisor does not open next connection to the same peer
+// until previous one is received from channel,
+// so it must be read all the time.
+func (s *PeerSupervisor) Conns() <-chan *YggConn {
+	return s.conns
+}
+
+// Adds peer to the set of supervised peers.
+//
+// Returns false if peer is already added or supervisor is stopped.
+// Returns static.InvalidUriError if uri is not valid peer uri.
+func (s *PeerSupervisor) AddPeer(uri url.URL) (bool, error) {
+	if _, err := static.PeerURIFromURL(uri); err != nil {
+		return false, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := uri.String()
+	if _, ok := s.peers[key]; ok || s.stopped {
+		return false, nil
+	}
+	ctx, cancel := context.WithCancel(s.manager.ctx)
+	s.peers[key] = cancel
+	s.wg.Add(1)
+	go s.supervise(ctx, uri)
+	return true, nil
+}
+
+// Removes peer from the set of supervised peers
+// and closes connection with it if one is opened.
+//
+// Returns false if peer was not added.
+func (s *PeerSupervisor) RemovePeer(uri url.URL) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := uri.String()
+	cancel, ok := s.peers[key]
+	if ok {
+		cancel()
+		delete(s.peers, key)
+	}
+	return ok
+}
+
+// Returns list of supervised peers.
+func (s *PeerSupervisor) Peers() []url.URL {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	peers := make([]url.URL, 0, len(s.peers))
+	for key := range s.peers {
+		if uri, err := url.Parse(key); err == nil {
+			peers = append(peers, *uri)
+		}
+	}
+	return peers
+}
+
+// Opens connection and waits for handshake pkg from the peer.
+// Returns nil on failure or if ctx is done.
+func (s *PeerSupervisor) connect(ctx context.Context, uri url.URL) *YggConn {
+	conn, err := s.manager.ConnectCtx(ctx, uri)
+	if err != nil {
+		return nil
+	}
+	handshaked := make(chan error, 1)
+	go func() {
+		_, err := conn.GetPublicKey()
+		handshaked <- err
+	}()
+	select {
+	case err = <-handshaked:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		// Closing also unblocks waiting goroutine
+		conn.Close()
+		return nil
+	}
+	return conn
+}
+
+// Passes connection to the reader and waits until it is closed.
+// Returns false if supervision of the peer is stopped.
+func (s *PeerSupervisor) serve(ctx context.Context, conn *YggConn) bool {
+	select {
+	case s.conns <- conn:
+	case <-ctx.Done():
+		conn.Close()
+		return false
+	}
+	select {
+	case <-conn.Closed():
+		return true
+	case <-ctx.Done():
+		conn.Close()
+		return false
+	}
+}
+
+func (s *PeerSupervisor) supervise(ctx context.Context, uri url.URL) {
+	defer s.wg.Done()
+	maxBackoff := maxBackoffFromUri(uri)
+	var attempt uint = 0
+	for {
+		if conn := s.connect(ctx, uri); conn != nil {
+			attempt = 0
+			if !s.serve(ctx, conn) {
+				return
+			}
+		}
+		timer := time.NewTimer(backoffDelay(attempt, maxBackoff))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		attempt++
+	}
+}
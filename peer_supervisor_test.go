@@ -0,0 +1,218 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ytl
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/Yggdrasil-Unofficial/ytl/debugstuff"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newSupervisorTestManager(ctx context.Context) *ConnManager {
+	manager := NewConnManager(ctx, nil, nil, nil, nil)
+	manager.transports["a"] = debugstuff.MockTransport{Scheme: "a", SecureLvl: 0}
+	return manager
+}
+
+func receiveSupervisedConn(t *testing.T, s *PeerSupervisor) *YggConn {
+	select {
+	case conn, ok := <-s.Conns():
+		if !ok {
+			t.Fatalf("Conns channel was closed")
+		}
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Connection was not opened")
+	}
+	return nil
+}
+
+func TestMaxBackoffFromUri(t *testing.T) {
+	cases := map[string]time.Duration{
+		"a://b":                 DEFAULT_MAX_BACKOFF(),
+		"a://b?maxbackoff=wtf":  DEFAULT_MAX_BACKOFF(),
+		"a://b?maxbackoff=1s":   MIN_MAX_BACKOFF(),
+		"a://b?maxbackoff=1m":   time.Minute,
+		"a://b?maxbackoff=100h": 100 * time.Hour,
+	}
+	for raw, expected := range cases {
+		uri, _ := url.Parse(raw)
+		if d := maxBackoffFromUri(*uri); d != expected {
+			t.Errorf("Wrong max backoff for %s: %s", raw, d)
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for n := uint(0); n < 40; n++ {
+		d := backoffDelay(n, time.Minute)
+		limit := time.Second << n
+		if n > 32 || limit > time.Minute {
+			limit = time.Minute
+		}
+		if d < limit/2 || d > limit {
+			t.Errorf("Delay %s for attempt %d is out of range", d, n)
+		}
+	}
+}
+
+func TestPeerSupervisorReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewPeerSupervisor(newSupervisorTestManager(ctx))
+	uri, _ := url.Parse("a://b")
+	if ok, err := s.AddPeer(*uri); !ok || err != nil {
+		t.Fatalf("Peer must be added: %v", err)
+	}
+	if ok, _ := s.AddPeer(*uri); ok {
+		t.Fatalf("Peer must not be added twice")
+	}
+	if len(s.Peers()) != 1 {
+		t.Fatalf("Wrong peers count: %d", len(s.Peers()))
+	}
+	conn := receiveSupervisedConn(t, s)
+	conn.Close()
+	conn = receiveSupervisedConn(t, s)
+	defer conn.Close()
+}
+
+func TestPeerSupervisorRemovePeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewPeerSupervisor(newSupervisorTestManager(ctx))
+	uri, _ := url.Parse("a://b")
+	s.AddPeer(*uri)
+	conn := receiveSupervisedConn(t, s)
+	if !s.RemovePeer(*uri) {
+		t.Fatalf("Peer must be removed")
+	}
+	if s.RemovePeer(*uri) {
+		t.Fatalf("Peer must not be removed twice")
+	}
+	select {
+	case <-conn.Closed():
+	case <-time.After(time.Second):
+		t.Fatalf("Connection was not closed")
+	}
+	select {
+	case <-s.Conns():
+		t.Fatalf("Removed peer must not be reconnected")
+	case <-time.After(2 * time.Second):
+	}
+}
+
+func TestPeerSupervisorBrokenPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewPeerSupervisor(newSupervisorTestManager(ctx))
+	uri, _ := url.Parse("a://b?error=true")
+	s.AddPeer(*uri)
+	select {
+	case <-s.Conns():
+		t.Fatalf("Connection must not be opened")
+	case <-time.After(2 * time.Second):
+	}
+}
+
+func TestPeerSupervisorStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewPeerSupervisor(newSupervisorTestManager(ctx))
+	uri, _ := url.Parse("a://b")
+	s.AddPeer(*uri)
+	conn := receiveSupervisedConn(t, s)
+	cancel()
+	select {
+	case <-conn.Closed():
+	case <-time.After(time.Second):
+		t.Fatalf("Connection was not closed")
+	}
+	select {
+	case _, ok := <-s.Conns():
+		if ok {
+			t.Fatalf("Conns channel must be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Conns channel was not closed")
+	}
+	if ok, _ := s.AddPeer(*uri); ok {
+		t.Fatalf("Stopped supervisor must not accept peers")
+	}
+}
+
+func TestPeerSupervisorInvalidPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewPeerSupervisor(newSupervisorTestManager(ctx))
+	uri, _ := url.Parse("a://b?key=invalid")
+	ok, err := s.AddPeer(*uri)
+	if ok {
+		t.Fatalf("Invalid peer must not be added")
+	}
+	if _, isInvalid := err.(static.InvalidUriError); !isInvalid {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(s.Peers()) != 0 {
+		t.Fatalf("Wrong peers count: %d", len(s.Peers()))
+	}
+}
+
+// Conn with local address from yggdrasil network
+type yggAddrConn struct {
+	net.Conn
+}
+
+func (yggAddrConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("200::1"), Port: 1}
+}
+
+// Transport that opens connections rejected by address check
+type yggAddrTransport struct {
+	debugstuff.MockTransport
+}
+
+func (t yggAddrTransport) Connect(ctx context.Context, uri url.URL, proxy *url.URL, key ed25519.PrivateKey) (static.ConnResult, error) {
+	conn, _ := net.Pipe()
+	return static.ConnResult{Conn: yggAddrConn{conn}}, nil
+}
+
+func TestPeerSupervisorRejectedAddress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	manager := NewConnManager(ctx, nil, nil, nil, nil)
+	manager.transports["a"] = yggAddrTransport{debugstuff.MockTransport{Scheme: "a"}}
+	s := NewPeerSupervisor(manager)
+	uri, _ := url.Parse("a://b")
+	s.AddPeer(*uri)
+	select {
+	case <-s.Conns():
+		t.Fatalf("Rejected connection must not be passed")
+	case <-time.After(500 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case _, ok := <-s.Conns():
+		if ok {
+			t.Fatalf("Conns channel must be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Supervisor was not stopped")
+	}
+}
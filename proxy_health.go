@@ -0,0 +1,207 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ytl
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Default interval between health checks of candidate proxies
+func DEFAULT_PROXY_CHECK_INTERVAL() time.Duration {
+	return 30 * time.Second
+}
+
+// Max time to wait for response of proxy during health check
+func PROXY_CHECK_TIMEOUT() time.Duration {
+	return 10 * time.Second
+}
+
+// Health of candidate proxies keyed by uri
+type proxyHealth struct {
+	mutex     sync.Mutex
+	unhealthy map[string]bool
+	running   bool
+	// Replaced in tests
+	probe func(ctx context.Context, proxy *url.URL) error
+}
+
+func newProxyHealth() *proxyHealth {
+	return &proxyHealth{unhealthy: make(map[string]bool), probe: probeProxy}
+}
+
+// Returns the first healthy candidate.
+// If all candidates are unhealthy, the first one is returned,
+// so connection still fails instead of going directly.
+func (h *proxyHealth) best(candidates []*url.URL) *url.URL {
+	if h == nil {
+		return candidates[0]
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, candidate := range candidates {
+		if !h.unhealthy[candidate.String()] {
+			return candidate
+		}
+	}
+	return candidates[0]
+}
+
+func (h *proxyHealth) isHealthy(proxy *url.URL) bool {
+	if h == nil {
+		return true
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return !h.unhealthy[proxy.String()]
+}
+
+// Probes all proxies concurrently and stores results
+func (h *proxyHealth) check(ctx context.Context, proxies []*url.URL) {
+	wg := sync.WaitGroup{}
+	for _, proxy := range proxies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, PROXY_CHECK_TIMEOUT())
+			defer cancel()
+			err := h.probe(probeCtx, proxy)
+			if ctx.Err() != nil {
+				// Checks are stopped, result means nothing
+				return
+			}
+			h.mutex.Lock()
+			h.unhealthy[proxy.String()] = err != nil
+			h.mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+// Checks that proxy accepts connections and responds to handshake.
+// Socks proxies must accept greeting with methods
+// "no authentication" or "username/password" (if uri has userinfo),
+// https proxies must complete tls handshake.
+func probeProxy(ctx context.Context, proxy *url.URL) error {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", proxy.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+	switch proxy.Scheme {
+	case "socks", "socks5", "socks5h":
+		greeting := []byte{5, 1, 0}
+		if proxy.User != nil {
+			greeting = []byte{5, 1, 2}
+		}
+		if _, err := conn.Write(greeting); err != nil {
+			return err
+		}
+		reply := make([]byte, 2)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[0] != 5 || reply[1] != greeting[2] {
+			return static.ProxyHandshakeError{
+				Proxy: *proxy,
+				Text:  fmt.Sprintf("unexpected socks reply %v", reply),
+			}
+		}
+	case "https":
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		return tlsConn.HandshakeContext(ctx)
+	}
+	return nil
+}
+
+// Returns unique candidates of all mappings
+func (p *ProxyManager) candidates() []*url.URL {
+	seen := make(map[string]bool)
+	result := make([]*url.URL, 0)
+	for _, mapping := range p.mapping {
+		if len(mapping.Chain) > 0 {
+			continue
+		}
+		for _, candidate := range mapping.Candidates {
+			if !seen[candidate.String()] {
+				seen[candidate.String()] = true
+				result = append(result, candidate)
+			}
+		}
+	}
+	return result
+}
+
+// Starts probing of candidate proxies in background
+// until ctx is canceled. Unhealthy candidates are skipped by Get.
+// Zero interval means DEFAULT_PROXY_CHECK_INTERVAL.
+//
+// Nothing is started if there are no candidates
+// or checks are already running.
+// ConnManager starts checks of its ProxyManager itself.
+func (p *ProxyManager) StartHealthChecks(ctx context.Context, interval time.Duration) {
+	candidates := p.candidates()
+	if p.health == nil || len(candidates) == 0 {
+		return
+	}
+	if interval <= 0 {
+		interval = DEFAULT_PROXY_CHECK_INTERVAL()
+	}
+	p.health.mutex.Lock()
+	defer p.health.mutex.Unlock()
+	if p.health.running {
+		return
+	}
+	p.health.running = true
+	go func() {
+		defer func() {
+			p.health.mutex.Lock()
+			p.health.running = false
+			p.health.mutex.Unlock()
+		}()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.health.check(ctx, candidates)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Returns false if the last health check of proxy failed
+func (p *ProxyManager) IsHealthy(proxy *url.URL) bool {
+	return p.health.isHealthy(proxy)
+}
@@ -17,18 +17,95 @@
 package ytl
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"net/netip"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
+	"sync"
 )
 
+// Actions of ProxyMapping
+const (
+	// Connect via proxies of mapping
+	PROXY_ACTION_PROXY uint8 = 0
+	// Connect directly even if default proxy is set
+	PROXY_ACTION_DIRECT = 1
+	// Do not connect at all
+	PROXY_ACTION_BLOCK = 2
+)
+
+// Stream isolation modes of ProxyMapping
+const (
+	// Proxy uris are used as is
+	PROXY_ISOLATION_NONE uint8 = 0
+	// Each peer uri gets its own socks credentials
+	PROXY_ISOLATION_PEER = 1
+	// Each connection gets its own socks credentials
+	PROXY_ISOLATION_CONN = 2
+)
+
+// Inclusive range of ports
+type PortRange struct {
+	From uint16
+	To   uint16
+}
+
+func (r PortRange) contains(port uint16) bool {
+	return port >= r.From && port <= r.To
+}
+
 // ProxyMapping is a representation of the correspondence
 // between hosts that fall under the regular expression
 // and proxy that should be used to connect to these hosts.
+//
+// Mapping matches uri only if all its non-empty conditions match.
+// Conditions of mapping with the same type (e.g. Networks) are alternatives.
 type ProxyMapping struct {
-	// RegExp for host matching
+	// RegExp for host matching (zero value matches any host)
 	HostRegexp regexp.Regexp
-	// Proxy (may be nil)
+	// Destination networks.
+	// Only ip literal hosts match unless ResolveHost is set.
+	Networks []netip.Prefix
+	// Resolve host names locally to check Networks.
+	// Resolving leaks dns queries past proxies,
+	// so do not set it for hosts that must be hidden (e.g. .onion)
+	// and place such mappings before this one.
+	ResolveHost bool
+	// Destination ports.
+	// Uris without explicit port do not match.
+	Ports []PortRange
+	// Transport schemes (e.g. "tcp", "tls")
+	Schemes []string
+	// Keys pinned by "key" param of uri
+	Keys []ed25519.PublicKey
+	// One of PROXY_ACTION_* constants.
+	// With PROXY_ACTION_DIRECT and PROXY_ACTION_BLOCK
+	// proxies of mapping are ignored.
+	Action uint8
+	// Proxy (may be nil).
+	// Tcp based transports support "socks", "socks5", "socks5h",
+	// "http" and "https" schemes.
 	Proxy *url.URL
+	// Ordered chain of proxies, connection is tunneled through each of them.
+	// If it is not empty, Proxy and Candidates are ignored.
+	Chain []*url.URL
+	// Alternative proxies in order of preference.
+	// The first one that passes health checks is used
+	// (see ProxyManager.StartHealthChecks).
+	// If it is not empty, Proxy is ignored.
+	Candidates []*url.URL
+	// One of PROXY_ISOLATION_* constants.
+	// Socks proxies without userinfo get random username and password,
+	// so Tor puts streams to separate circuits (see IsolateSOCKSAuth).
+	Isolation uint8
 }
 
 // Stores ProxyMappings and match
@@ -36,21 +113,210 @@ type ProxyMapping struct {
 type ProxyManager struct {
 	defaultProxy *url.URL
 	mapping      []ProxyMapping
+	// Shared by copies of manager
+	health *proxyHealth
+	// Key for credentials of PROXY_ISOLATION_PEER
+	isolationSecret []byte
 }
 
 func NewProxyManager(defaultProxy *url.URL, mapping []ProxyMapping) ProxyManager {
 	if mapping == nil {
 		mapping = make([]ProxyMapping, 0)
 	}
-	return ProxyManager{defaultProxy, mapping}
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return ProxyManager{defaultProxy, mapping, newProxyHealth(), secret}
+}
+
+// Returns chain of proxies of mapping
+func (p *ProxyManager) chain(m ProxyMapping, uri url.URL) []*url.URL {
+	var chain []*url.URL = nil
+	if len(m.Chain) > 0 {
+		chain = append([]*url.URL(nil), m.Chain...)
+	} else if len(m.Candidates) > 0 {
+		chain = []*url.URL{p.health.best(m.Candidates)}
+	} else if m.Proxy != nil {
+		chain = []*url.URL{m.Proxy}
+	}
+	if m.Isolation == PROXY_ISOLATION_NONE {
+		return chain
+	}
+	user := p.isolationUser(m.Isolation, uri)
+	for i, hop := range chain {
+		switch hop.Scheme {
+		case "socks", "socks5", "socks5h":
+			if hop.User == nil {
+				isolated := *hop
+				isolated.User = user
+				chain[i] = &isolated
+			}
+		}
+	}
+	return chain
+}
+
+// Key for credentials of PROXY_ISOLATION_PEER
+// of managers created without NewProxyManager
+var (
+	fallbackIsolationOnce   sync.Once
+	fallbackIsolationSecret []byte
+)
+
+// Returns key for credentials of PROXY_ISOLATION_PEER.
+// It is generated lazily if manager was created without NewProxyManager.
+func (p *ProxyManager) secret() []byte {
+	if len(p.isolationSecret) > 0 {
+		return p.isolationSecret
+	}
+	fallbackIsolationOnce.Do(func() {
+		fallbackIsolationSecret = make([]byte, 32)
+		rand.Read(fallbackIsolationSecret)
+	})
+	return fallbackIsolationSecret
+}
+
+// Returns random socks credentials.
+// With PROXY_ISOLATION_PEER they are derived from uri,
+// so they are the same for all connections to the peer.
+func (p *ProxyManager) isolationUser(isolation uint8, uri url.URL) *url.Userinfo {
+	sum := make([]byte, 32)
+	if isolation == PROXY_ISOLATION_PEER {
+		mac := hmac.New(sha256.New, p.secret())
+		mac.Write([]byte(uri.String()))
+		sum = mac.Sum(nil)
+	} else {
+		rand.Read(sum)
+	}
+	return url.UserPassword(hex.EncodeToString(sum[:16]), hex.EncodeToString(sum[16:]))
+}
+
+// Checks conditions of mapping except Networks
+func (m ProxyMapping) matchStatic(uri url.URL) bool {
+	if m.HostRegexp.String() != "" && !m.HostRegexp.MatchString(uri.Host) {
+		return false
+	}
+	if len(m.Ports) > 0 {
+		port, err := strconv.ParseUint(uri.Port(), 10, 16)
+		if err != nil {
+			return false
+		}
+		if !slices.ContainsFunc(m.Ports, func(r PortRange) bool {
+			return r.contains(uint16(port))
+		}) {
+			return false
+		}
+	}
+	if len(m.Schemes) > 0 && !slices.Contains(m.Schemes, uri.Scheme) {
+		return false
+	}
+	if len(m.Keys) > 0 {
+		peer, err := static.PeerURIFromURL(uri)
+		if err != nil {
+			return false
+		}
+		if !slices.ContainsFunc(m.Keys, func(key ed25519.PublicKey) bool {
+			return slices.ContainsFunc(peer.Keys, func(pinned ed25519.PublicKey) bool {
+				return key.Equal(pinned)
+			})
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m ProxyMapping) matchNetworks(addrs []netip.Addr) bool {
+	for _, network := range m.Networks {
+		for _, addr := range addrs {
+			if network.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-// Retruns proxy matched to URI by it host
+// Returns address of uri host if it is ip literal
+func literalHost(uri url.URL) []netip.Addr {
+	if addr, err := netip.ParseAddr(uri.Hostname()); err == nil {
+		return []netip.Addr{addr.Unmap()}
+	}
+	return nil
+}
+
+// Returns addresses of uri host.
+// Resolving errors are ignored, so networks just do not match.
+func resolveHost(ctx context.Context, resolver static.Resolver, uri url.URL) []netip.Addr {
+	if addrs := literalHost(uri); addrs != nil {
+		return addrs
+	}
+	host := uri.Hostname()
+	ips, err := static.ResolverOrDefault(resolver).LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	addrs := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		if addr, ok := netip.AddrFromSlice(ip.IP); ok {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	return addrs
+}
+
+// Retruns proxy matched to URI by it host.
+// For chain of proxies the first hop is returned.
 func (p *ProxyManager) Get(uri url.URL) *url.URL {
+	if chain := p.GetChain(uri); len(chain) > 0 {
+		return chain[0]
+	}
+	return nil
+}
+
+// Retruns chain of proxies matched to URI by it host
+// or nil for direct connection.
+// Blocked uris also give nil, use GetChainCtx to distinguish them.
+func (p *ProxyManager) GetChain(uri url.URL) []*url.URL {
+	chain, _ := p.GetChainCtx(context.Background(), uri, nil)
+	return chain
+}
+
+// Retruns chain of proxies matched to URI by the first matching mapping
+// or nil for direct connection.
+// For blocked uri static.DestinationBlockedError is returned.
+// Resolver is used for Networks condition of mappings with ResolveHost,
+// nil means system resolver.
+func (p *ProxyManager) GetChainCtx(ctx context.Context, uri url.URL, resolver static.Resolver) ([]*url.URL, error) {
+	literal := literalHost(uri)
+	var resolvedAddrs []netip.Addr = nil
+	resolved := false
 	for _, mapping := range p.mapping {
-		if mapping.HostRegexp.MatchString(uri.Host) {
-			return mapping.Proxy
+		if !mapping.matchStatic(uri) {
+			continue
 		}
+		if len(mapping.Networks) > 0 {
+			addrs := literal
+			if addrs == nil && mapping.ResolveHost {
+				if !resolved {
+					resolvedAddrs = resolveHost(ctx, resolver, uri)
+					resolved = true
+				}
+				addrs = resolvedAddrs
+			}
+			if !mapping.matchNetworks(addrs) {
+				continue
+			}
+		}
+		switch mapping.Action {
+		case PROXY_ACTION_DIRECT:
+			return nil, nil
+		case PROXY_ACTION_BLOCK:
+			return nil, static.DestinationBlockedError{Host: uri.Host}
+		}
+		return p.chain(mapping, uri), nil
+	}
+	if p.defaultProxy != nil {
+		return []*url.URL{p.defaultProxy}, nil
 	}
-	return p.defaultProxy
+	return nil, nil
 }
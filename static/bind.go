@@ -0,0 +1,50 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package static
+
+import (
+	"net"
+)
+
+// BindOptions configures local side of outgoing connections
+type BindOptions struct {
+	// Local ip address, nil means any
+	Source net.IP
+	// Network interface to bind socket to (SO_BINDTODEVICE, linux only)
+	Interface string
+	// Firewall mark of socket (SO_MARK, linux only), zero means no mark
+	Mark uint32
+}
+
+// Returns true if no option is set
+func (o BindOptions) IsZero() bool {
+	return o.Source == nil && o.Interface == "" && o.Mark == 0
+}
+
+// Returns copy of options with fields replaced by non-zero fields of other
+func (o BindOptions) Override(other BindOptions) BindOptions {
+	if other.Source != nil {
+		o.Source = other.Source
+	}
+	if other.Interface != "" {
+		o.Interface = other.Interface
+	}
+	if other.Mark != 0 {
+		o.Mark = other.Mark
+	}
+	return o
+}
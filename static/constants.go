@@ -24,11 +24,24 @@ const (
 	SECURE_LVL_ENCRYPTED_AND_VERIFIED      = 3
 )
 
+// Directions of connections
+const (
+	DIRECTION_OUTBOUND uint = 0
+	DIRECTION_INBOUND       = 1
+)
+
 // Returns current supported version of yggdrasil protocol
+// with fixed-size handshake pkg
 func PROTO_VERSION() ProtoVersion {
 	return ProtoVersion{0, 4}
 }
 
+// Returns current supported version of yggdrasil protocol
+// with TLV handshake pkg
+func PROTO_VERSION_TLV() ProtoVersion {
+	return ProtoVersion{0, 5}
+}
+
 // Returns static header of first pkg in yggdrasil connection
 func META_HEADER() []byte {
 	return []byte{'m', 'e', 't', 'a'}
@@ -77,7 +77,7 @@ type InapplicableProxyTypeError struct {
 func (e InapplicableProxyTypeError) Error() string {
 	u := url.URL(e.Proxy)
 	url := &u
-	return fmt.Sprintf("Proxy type '%s' cannot use with '%s' transport", url.String(), e.Transport)
+	return fmt.Sprintf("Proxy type '%s' cannot use with '%s' transport", url.Redacted(), e.Transport)
 }
 
 func (e InapplicableProxyTypeError) Timeout() bool { return false }
@@ -149,3 +149,111 @@ func (e UnacceptableAddressError) Error() string {
 func (e UnacceptableAddressError) Timeout() bool { return false }
 
 func (e UnacceptableAddressError) Temporary() bool { return false }
+
+type InvalidHandshakeSignatureError struct{}
+
+func (e InvalidHandshakeSignatureError) Error() string {
+	return fmt.Sprintf("Handshake signature is invalid")
+}
+
+func (e InvalidHandshakeSignatureError) Timeout() bool { return false }
+
+func (e InvalidHandshakeSignatureError) Temporary() bool { return false }
+
+type IncorrectPasswordError struct{}
+
+func (e IncorrectPasswordError) Error() string {
+	return fmt.Sprintf("Peer password is incorrect")
+}
+
+func (e IncorrectPasswordError) Timeout() bool { return false }
+
+func (e IncorrectPasswordError) Temporary() bool { return false }
+
+type ManagerClosedError struct{}
+
+func (e ManagerClosedError) Error() string {
+	return fmt.Sprintf("Connection manager is closed")
+}
+
+func (e ManagerClosedError) Timeout() bool { return false }
+
+func (e ManagerClosedError) Temporary() bool { return false }
+
+type UnknownInterfaceError struct {
+	Name string
+}
+
+func (e UnknownInterfaceError) Error() string {
+	return fmt.Sprintf("Network interface %s does not exist", e.Name)
+}
+
+func (e UnknownInterfaceError) Timeout() bool { return false }
+
+func (e UnknownInterfaceError) Temporary() bool { return false }
+
+type UnsupportedSocketOptionError struct {
+	Option string
+}
+
+func (e UnsupportedSocketOptionError) Error() string {
+	return fmt.Sprintf("Socket option %s is not supported on this platform", e.Option)
+}
+
+func (e UnsupportedSocketOptionError) Timeout() bool { return false }
+
+func (e UnsupportedSocketOptionError) Temporary() bool { return false }
+
+type ProxyHopError struct {
+	// Number of failed proxy in chain starting from 1
+	Hop   int
+	Proxy url.URL
+	Err   error
+}
+
+func (e ProxyHopError) Error() string {
+	return fmt.Sprintf("Proxy hop %d (%s) failed: %s", e.Hop, e.Proxy.Redacted(), e.Err)
+}
+
+func (e ProxyHopError) Unwrap() error { return e.Err }
+
+func (e ProxyHopError) Timeout() bool { return false }
+
+func (e ProxyHopError) Temporary() bool { return false }
+
+type ProxyHandshakeError struct {
+	Proxy url.URL
+	Text  string
+}
+
+func (e ProxyHandshakeError) Error() string {
+	return fmt.Sprintf("Proxy %s handshake failed: %s", e.Proxy.Redacted(), e.Text)
+}
+
+func (e ProxyHandshakeError) Timeout() bool { return false }
+
+func (e ProxyHandshakeError) Temporary() bool { return false }
+
+type ProxyChainUnsupportedError struct {
+	Transport string
+}
+
+func (e ProxyChainUnsupportedError) Error() string {
+	return fmt.Sprintf("Transport '%s' does not support chains of proxies", e.Transport)
+}
+
+func (e ProxyChainUnsupportedError) Timeout() bool { return false }
+
+func (e ProxyChainUnsupportedError) Temporary() bool { return false }
+
+type DestinationBlockedError struct {
+	Host string
+}
+
+func (e DestinationBlockedError) Error() string {
+	return fmt.Sprintf("Connections to %s are blocked by proxy rules", e.Host)
+}
+
+func (e DestinationBlockedError) Timeout() bool { return false }
+
+func (e DestinationBlockedError) Temporary() bool { return false }
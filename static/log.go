@@ -0,0 +1,105 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package static
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"log/slog"
+	"net/url"
+)
+
+// Handler that drops all records
+type nopHandler struct{}
+
+func (nopHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (nopHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h nopHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h nopHandler) WithGroup(string) slog.Handler           { return h }
+
+// Returns logger that drops all records
+func NopLogger() *slog.Logger {
+	return slog.New(nopHandler{})
+}
+
+// If logger is not nil, returns it as is.
+// Otherwise returns NopLogger.
+func LoggerOrNop(logger *slog.Logger) *slog.Logger {
+	if logger != nil {
+		return logger
+	}
+	return NopLogger()
+}
+
+// Returns log attribute with hex encoded public key
+func KeyAttr(name string, key ed25519.PublicKey) slog.Attr {
+	if key == nil {
+		return slog.String(name, "")
+	}
+	return slog.String(name, hex.EncodeToString(key))
+}
+
+const redactedSecret = "xxxxx"
+
+// Returns proxy uri with masked password of userinfo
+func redactProxy(raw string) string {
+	proxy, err := url.Parse(raw)
+	if err != nil || proxy.User == nil {
+		return raw
+	}
+	if _, ok := proxy.User.Password(); !ok {
+		return raw
+	}
+	proxy.User = url.UserPassword(proxy.User.Username(), redactedSecret)
+	return proxy.String()
+}
+
+// Returns copy of peer uri that is safe to log or expose.
+// Password of userinfo, "password" param and passwords of
+// proxies in "proxy" param are masked.
+func RedactURL(uri url.URL) url.URL {
+	if uri.User != nil {
+		if _, ok := uri.User.Password(); ok {
+			uri.User = url.UserPassword(uri.User.Username(), redactedSecret)
+		}
+	}
+	query := uri.Query()
+	changed := false
+	for i, password := range query["password"] {
+		if password != "" {
+			query["password"][i] = redactedSecret
+			changed = true
+		}
+	}
+	for i, proxy := range query["proxy"] {
+		if redacted := redactProxy(proxy); redacted != proxy {
+			query["proxy"][i] = redacted
+			changed = true
+		}
+	}
+	if changed {
+		uri.RawQuery = query.Encode()
+	}
+	return uri
+}
+
+// Returns string form of RedactURL result
+func RedactedURI(uri url.URL) string {
+	redacted := RedactURL(uri)
+	return redacted.String()
+}
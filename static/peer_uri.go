@@ -0,0 +1,252 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package static
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value of "proxy" uri param that disables proxy for the peer
+const PROXY_DIRECT = "direct"
+
+// PeerURI is a parsed and validated uri of peer.
+//
+// Known query params are available as typed fields,
+// unknown ones are kept in Extra.
+type PeerURI struct {
+	Scheme string
+	User   *url.Userinfo
+	// Host with optional port
+	Host string
+	Path string
+	// Opaque part of unix socket uri like "unix:relative/path"
+	Opaque string
+	// Keys pinned by "key" params
+	Keys []ed25519.PublicKey
+	// Value of "sni" param
+	SNI string
+	// Value of "password" param
+	Password string
+	// Value of "priority" param
+	Priority uint8
+	// Value of "maxbackoff" param, zero if missing
+	MaxBackoff time.Duration
+	// Proxy set by "proxy" param, overrides ProxyManager
+	Proxy *url.URL
+	// True if "proxy" param is "direct",
+	// so connection must not use any proxy
+	Direct bool
+	// Network interface set by "bind" param
+	Bind string
+	// Local ip address set by "source" param
+	Source net.IP
+	// Firewall mark set by "mark" param, decimal or hex with 0x prefix
+	Mark uint32
+	// Unknown params
+	Extra url.Values
+}
+
+// Parses and validates peer uri
+func ParsePeerURI(raw string) (PeerURI, error) {
+	uri, err := url.Parse(raw)
+	if err != nil {
+		return PeerURI{}, InvalidUriError{Err: err.Error()}
+	}
+	return PeerURIFromURL(*uri)
+}
+
+// Validates peer uri and parses its params
+func PeerURIFromURL(uri url.URL) (peer PeerURI, err error) {
+	if uri.Scheme == "" {
+		err = InvalidUriError{Err: "scheme is missing"}
+		return
+	}
+	// Only unix sockets have opaque form ("unix:@name" or "unix:relative/path")
+	if uri.Opaque != "" && strings.ToLower(uri.Scheme) != "unix" {
+		err = InvalidUriError{Err: "host or path is missing"}
+		return
+	}
+	if uri.Host == "" && uri.Path == "" && uri.Opaque == "" {
+		err = InvalidUriError{Err: "host or path is missing"}
+		return
+	}
+	query, e := url.ParseQuery(uri.RawQuery)
+	if e != nil {
+		err = InvalidUriError{Err: e.Error()}
+		return
+	}
+	peer = PeerURI{
+		Scheme: strings.ToLower(uri.Scheme),
+		User:   uri.User,
+		Host:   strings.ToLower(uri.Host),
+		Path:   uri.Path,
+		Opaque: uri.Opaque,
+		Extra:  url.Values{},
+	}
+	for name, values := range query {
+		value := values[len(values)-1]
+		if value == "" && name != "key" && !isExtraParam(name) {
+			// Empty params are the same as missing ones
+			continue
+		}
+		switch name {
+		case "key":
+			for _, value := range values {
+				key, e := hex.DecodeString(value)
+				if e != nil || len(key) != ed25519.PublicKeySize {
+					err = InvalidUriError{Err: "malformed key " + value}
+					return
+				}
+				peer.Keys = append(peer.Keys, key)
+			}
+		case "sni":
+			peer.SNI = value
+		case "password":
+			peer.Password = value
+		case "priority":
+			priority, e := strconv.ParseUint(value, 10, 8)
+			if e != nil {
+				err = InvalidUriError{Err: "malformed priority " + value}
+				return
+			}
+			peer.Priority = uint8(priority)
+		case "maxbackoff":
+			backoff, e := time.ParseDuration(value)
+			if e != nil || backoff <= 0 {
+				err = InvalidUriError{Err: "malformed maxbackoff " + value}
+				return
+			}
+			peer.MaxBackoff = backoff
+		case "proxy":
+			if value == PROXY_DIRECT {
+				peer.Direct = true
+				break
+			}
+			proxy, e := url.Parse(value)
+			if e != nil || proxy.Scheme == "" || proxy.Host == "" {
+				err = InvalidUriError{Err: "malformed proxy " + value}
+				return
+			}
+			peer.Proxy = proxy
+		case "bind":
+			peer.Bind = value
+		case "source":
+			source := net.ParseIP(value)
+			if source == nil {
+				err = InvalidUriError{Err: "malformed source " + value}
+				return
+			}
+			peer.Source = source
+		case "mark":
+			mark, e := strconv.ParseUint(value, 0, 32)
+			if e != nil {
+				err = InvalidUriError{Err: "malformed mark " + value}
+				return
+			}
+			peer.Mark = uint32(mark)
+		default:
+			peer.Extra[name] = values
+		}
+	}
+	return
+}
+
+func isExtraParam(name string) bool {
+	switch name {
+	case "key", "sni", "password", "priority", "maxbackoff", "proxy",
+		"bind", "source", "mark":
+		return false
+	}
+	return true
+}
+
+// Returns canonical form of uri.
+//
+// Scheme and host are lowercased, keys are lowercased, sorted and deduplicated,
+// params are sorted by name and default values are omitted.
+func (p PeerURI) URL() url.URL {
+	query := url.Values{}
+	for name, values := range p.Extra {
+		query[name] = append([]string(nil), values...)
+	}
+	keys := make([]string, 0, len(p.Keys))
+	for _, key := range p.Keys {
+		keys = append(keys, hex.EncodeToString(key))
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i == 0 || keys[i-1] != key {
+			query.Add("key", key)
+		}
+	}
+	if p.SNI != "" {
+		query.Set("sni", p.SNI)
+	}
+	if p.Password != "" {
+		query.Set("password", p.Password)
+	}
+	if p.Priority != 0 {
+		query.Set("priority", strconv.FormatUint(uint64(p.Priority), 10))
+	}
+	if p.MaxBackoff != 0 {
+		query.Set("maxbackoff", p.MaxBackoff.String())
+	}
+	if p.Direct {
+		query.Set("proxy", PROXY_DIRECT)
+	} else if p.Proxy != nil {
+		query.Set("proxy", p.Proxy.String())
+	}
+	if p.Bind != "" {
+		query.Set("bind", p.Bind)
+	}
+	if p.Source != nil {
+		query.Set("source", p.Source.String())
+	}
+	if p.Mark != 0 {
+		query.Set("mark", strconv.FormatUint(uint64(p.Mark), 10))
+	}
+	return url.URL{
+		Scheme:   p.Scheme,
+		User:     p.User,
+		Host:     p.Host,
+		Path:     p.Path,
+		Opaque:   p.Opaque,
+		RawQuery: query.Encode(),
+	}
+}
+
+// Returns bind options set by "bind", "source" and "mark" params
+func (p PeerURI) BindOptions() BindOptions {
+	return BindOptions{
+		Source:    p.Source,
+		Interface: p.Bind,
+		Mark:      p.Mark,
+	}
+}
+
+// Returns canonical form of uri as string
+func (p PeerURI) String() string {
+	u := p.URL()
+	return u.String()
+}
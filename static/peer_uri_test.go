@@ -0,0 +1,121 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package static
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePeerURI(t *testing.T) {
+	key1 := strings.Repeat("ab", ed25519.PublicKeySize)
+	key2 := strings.Repeat("01", ed25519.PublicKeySize)
+	peer, err := ParsePeerURI(
+		"TLS://Example.COM:1234/path?key=" + strings.ToUpper(key1) + "&key=" + key2 +
+			"&key=" + key1 + "&sni=sni.example&password=secret&priority=3" +
+			"&maxbackoff=1m&proxy=socks://127.0.0.1:9050&other=1",
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if peer.Scheme != "tls" || peer.Host != "example.com:1234" || peer.Path != "/path" {
+		t.Errorf("Wrong address: %s %s %s", peer.Scheme, peer.Host, peer.Path)
+	}
+	if len(peer.Keys) != 3 || hex.EncodeToString(peer.Keys[1]) != key2 {
+		t.Errorf("Wrong keys: %v", peer.Keys)
+	}
+	if peer.SNI != "sni.example" || peer.Password != "secret" || peer.Priority != 3 {
+		t.Errorf("Wrong params: %v", peer)
+	}
+	if peer.MaxBackoff != time.Minute || peer.Proxy == nil || peer.Proxy.Host != "127.0.0.1:9050" {
+		t.Errorf("Wrong params: %v", peer)
+	}
+	if peer.Extra.Get("other") != "1" {
+		t.Errorf("Unknown params must be kept")
+	}
+	expected := "tls://example.com:1234/path?key=" + key2 + "&key=" + key1 +
+		"&maxbackoff=1m0s&other=1&password=secret&priority=3" +
+		"&proxy=socks%3A%2F%2F127.0.0.1%3A9050&sni=sni.example"
+	if peer.String() != expected {
+		t.Errorf("Wrong canonical form:\n%s\n%s", peer.String(), expected)
+	}
+	again, err := ParsePeerURI(peer.String())
+	if err != nil || again.String() != expected {
+		t.Errorf("Canonical form must be stable: %s %v", again.String(), err)
+	}
+}
+
+func TestParsePeerURIDefaults(t *testing.T) {
+	cases := map[string]string{
+		"unix:///tmp/ygg.sock":                  "unix:///tmp/ygg.sock",
+		"unix:@abstract":                        "unix:@abstract",
+		"unix:relative/ygg.sock?priority=1":     "unix:relative/ygg.sock?priority=1",
+		"tcp://host:1?priority=0&maxbackoff=":   "tcp://host:1",
+		"tcp://host:1?proxy=direct":             "tcp://host:1?proxy=direct",
+		"ws://host:1/ygg?bind=eth0&sni=&empty=": "ws://host:1/ygg?bind=eth0&empty=",
+	}
+	for raw, expected := range cases {
+		peer, err := ParsePeerURI(raw)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", raw, err)
+			continue
+		}
+		if peer.String() != expected {
+			t.Errorf("Expected %s, got %s", expected, peer.String())
+		}
+	}
+	peer, _ := ParsePeerURI("tcp://host:1?proxy=direct")
+	if !peer.Direct || peer.Proxy != nil {
+		t.Errorf("Proxy must be disabled")
+	}
+	peer, _ = ParsePeerURI("tcp://host:1?bind=eth0&source=10.0.0.1&mark=0x10")
+	bind := BindOptions{Mark: 1, Interface: "eth1"}.Override(peer.BindOptions())
+	if bind.Interface != "eth0" || bind.Mark != 16 || bind.Source.String() != "10.0.0.1" {
+		t.Errorf("Wrong bind options: %v", bind)
+	}
+}
+
+func TestParsePeerURIInvalid(t *testing.T) {
+	for _, raw := range []string{
+		"host:1",
+		"tcp://",
+		"tcp:host",
+		"unix:",
+		"tcp://host:1?key=00",
+		"tcp://host:1?key=zz",
+		"tcp://host:1?key=",
+		"tcp://host:1?priority=-1",
+		"tcp://host:1?priority=256",
+		"tcp://host:1?maxbackoff=wtf",
+		"tcp://host:1?maxbackoff=-1s",
+		"tcp://host:1?proxy=wtf",
+		"tcp://host:1?source=wtf",
+		"tcp://host:1?mark=-1",
+		"tcp://host:1?mark=0x100000000",
+		"tcp://host:1?a=%zz",
+		"%zz://host",
+	} {
+		if _, err := ParsePeerURI(raw); err == nil {
+			t.Errorf("Expected error for %s", raw)
+		} else if _, ok := err.(InvalidUriError); !ok {
+			t.Errorf("Expected InvalidUriError for %s, got %T", raw, err)
+		}
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"crypto/ed25519"
 	"crypto/subtle"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/url"
 )
@@ -39,6 +40,11 @@ func (e ProtoVersion) String() string {
 	return fmt.Sprintf("Version{%d.%d}", e.Major, e.Minor)
 }
 
+// Returns true if version is older than other.
+func (e ProtoVersion) Less(other ProtoVersion) bool {
+	return e.Major < other.Major || (e.Major == other.Major && e.Minor < other.Minor)
+}
+
 // AllowList is a list of public keys of nodes
 // that are allowed to communicate with the current.
 //
@@ -64,6 +70,14 @@ func (a *AllowList) IsAllow(key ed25519.PublicKey) bool {
 	return false
 }
 
+// PeerCred contains credentials of process
+// on other side of local (unix) socket.
+type PeerCred struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
 // ConnResult contains information received
 // when establishing a transport connection with another node
 type ConnResult struct {
@@ -78,6 +92,9 @@ type ConnResult struct {
 	// - ytl.static.SECURE_LVL_VERIFIED
 	// - ytl.static.SECURE_LVL_ENCRYPTED_AND_VERIFIED
 	SecurityLevel uint
+	// Optional credentials of peer process (may be nil).
+	// Available only for local sockets on supported platforms.
+	PeerCred *PeerCred
 }
 
 // TransportListener is similar to [net.Listener]
@@ -110,7 +127,7 @@ func (l *baseTransportListener) Accept() (net.Conn, error) {
 
 func (l *baseTransportListener) AcceptConn() (ConnResult, error) {
 	c, e := l.inner.Accept()
-	return ConnResult{c, nil, l.securityLevel}, e
+	return ConnResult{Conn: c, SecurityLevel: l.securityLevel}, e
 }
 
 func (l *baseTransportListener) Close() error {
@@ -139,3 +156,26 @@ type Transport interface {
 	// Returns listener object for accepting incoming transport connections.
 	Listen(ctx context.Context, uri url.URL, key ed25519.PrivateKey) (TransportListener, error)
 }
+
+// Options of a single connection attempt.
+// Zero value means defaults.
+type DialOptions struct {
+	// Nil means NopLogger
+	Logger *slog.Logger
+	// Nil means system resolver
+	Resolver Resolver
+	// Default bind options, overridden by transport and uri params
+	Bind BindOptions
+	// Proxies to tunnel connection through in turn, empty means direct connection
+	ProxyChain []*url.URL
+}
+
+// Transport that accepts DialOptions explicitly.
+// ConnManager uses ConnectWithOptions instead of Connect if it is implemented.
+type DialOptionsTransport interface {
+	Transport
+	ConnectWithOptions(
+		ctx context.Context, uri url.URL,
+		key ed25519.PrivateKey, options DialOptions,
+	) (ConnResult, error)
+}
@@ -0,0 +1,101 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package transports
+
+import (
+	"context"
+	"errors"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"net"
+)
+
+type acceptResult struct {
+	conn static.ConnResult
+	err  error
+}
+
+// Accepts connections in background and prepares them
+// (e.g. performs handshakes) concurrently,
+// so one slow peer does not block others.
+type asyncAcceptor struct {
+	results chan acceptResult
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Accept must return function preparing accepted connection.
+// Prepare must close connection if it fails,
+// ctx passed to it is canceled when acceptor is closed.
+func newAsyncAcceptor(
+	accept func(ctx context.Context) (func(ctx context.Context) (static.ConnResult, error), error),
+) *asyncAcceptor {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &asyncAcceptor{
+		results: make(chan acceptResult),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go a.loop(accept)
+	return a
+}
+
+func (a *asyncAcceptor) loop(
+	accept func(ctx context.Context) (func(ctx context.Context) (static.ConnResult, error), error),
+) {
+	for {
+		prepare, err := accept(a.ctx)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || a.ctx.Err() != nil {
+				a.cancel()
+				return
+			}
+			select {
+			case a.results <- acceptResult{err: err}:
+				continue
+			case <-a.ctx.Done():
+				return
+			}
+		}
+		go func() {
+			conn, err := prepare(a.ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case a.results <- acceptResult{conn: conn}:
+			case <-a.ctx.Done():
+				conn.Conn.Close()
+			}
+		}()
+	}
+}
+
+// Returns the next prepared connection
+func (a *asyncAcceptor) accept() (static.ConnResult, error) {
+	select {
+	case result := <-a.results:
+		return result.conn, result.err
+	case <-a.ctx.Done():
+		return static.ConnResult{}, net.ErrClosed
+	}
+}
+
+// Stops accepting and cancels preparing of pending connections.
+// Inner listener must be closed by caller.
+func (a *asyncAcceptor) close() {
+	a.cancel()
+}
@@ -0,0 +1,234 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package transports
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"github.com/Yggdrasil-Unofficial/ytl/addr"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"github.com/quic-go/quic-go"
+	"net"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// Exactly what the name implies
+const QuicScheme = "quic"
+
+// Same settings as in yggdrasil-go
+func quicConfig() *quic.Config {
+	return &quic.Config{
+		MaxIdleTimeout:  time.Minute,
+		KeepAlivePeriod: 20 * time.Second,
+		TokenStore:      quic.NewLRUTokenStore(255, 4),
+	}
+}
+
+// Wraps quic connection with single stream to [net.Conn].
+type quicStreamConn struct {
+	conn   quic.Connection
+	stream quic.Stream
+}
+
+func (c *quicStreamConn) Read(b []byte) (int, error) {
+	return c.stream.Read(b)
+}
+
+func (c *quicStreamConn) Write(b []byte) (int, error) {
+	return c.stream.Write(b)
+}
+
+// Closes both stream and whole quic connection.
+func (c *quicStreamConn) Close() error {
+	err := c.stream.Close()
+	if e := c.conn.CloseWithError(0, ""); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *quicStreamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *quicStreamConn) SetDeadline(t time.Time) error {
+	return c.stream.SetDeadline(t)
+}
+
+func (c *quicStreamConn) SetReadDeadline(t time.Time) error {
+	return c.stream.SetReadDeadline(t)
+}
+
+func (c *quicStreamConn) SetWriteDeadline(t time.Time) error {
+	return c.stream.SetWriteDeadline(t)
+}
+
+// Implements quic yggdrasil transport
+// Compatible with the same named transport in yggdrasil-go
+//
+// Each peer link uses exactly one quic stream.
+// Transport key is taken from peer certificate,
+// so security levels are the same as in TlsTransport.
+//
+// Quic can not be used with any proxy, so
+// static.InapplicableProxyTypeError is returned
+// if proxy was selected.
+type QuicTransport struct{}
+
+func (t QuicTransport) GetScheme() string {
+	return QuicScheme
+}
+
+// Resolves "host:port" with resolver (system one if nil).
+// Returns the first address.
+func resolveUdpAddr(ctx context.Context, resolver static.Resolver, hostport string) (*net.UDPAddr, error) {
+	host, service, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, "udp", service)
+	if err != nil {
+		return nil, err
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return &net.UDPAddr{IP: ip.AsSlice(), Port: port, Zone: ip.Zone()}, nil
+	}
+	ips, err := static.ResolverOrDefault(resolver).LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return &net.UDPAddr{IP: ips[0].IP, Port: port, Zone: ips[0].Zone}, nil
+}
+
+func (t QuicTransport) Connect(ctx context.Context, uri url.URL, proxy *url.URL, key ed25519.PrivateKey) (static.ConnResult, error) {
+	return t.ConnectWithOptions(ctx, uri, key, static.DialOptions{ProxyChain: chainOfProxy(proxy)})
+}
+
+func (t QuicTransport) ConnectWithOptions(ctx context.Context, uri url.URL, key ed25519.PrivateKey, options static.DialOptions) (static.ConnResult, error) {
+	if len(options.ProxyChain) > 0 {
+		return static.ConnResult{}, static.InapplicableProxyTypeError{
+			Transport: QuicScheme,
+			Proxy:     *options.ProxyChain[0],
+		}
+	}
+	config, err := tlsConfigFromKey(key)
+	if err != nil {
+		return static.ConnResult{}, err
+	}
+	pinned := pinnedKeysFromUri(uri)
+	config.ServerName = tlsServerName(uri)
+	config.VerifyPeerCertificate = verifyPeerKey(pinned, false)
+	dst, err := resolveUdpAddr(ctx, options.Resolver, uri.Host)
+	if err != nil {
+		return static.ConnResult{}, err
+	}
+	if err = addr.CheckAddr(dst.IP); err != nil {
+		return static.ConnResult{}, err
+	}
+	conn, err := quic.DialAddr(ctx, dst.String(), config, quicConfig())
+	if err != nil {
+		return static.ConnResult{}, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return static.ConnResult{}, err
+	}
+	var secureLvl uint = static.SECURE_LVL_ENCRYPTED
+	if len(pinned) > 0 {
+		secureLvl = static.SECURE_LVL_ENCRYPTED_AND_VERIFIED
+	}
+	return static.ConnResult{
+		Conn:          &quicStreamConn{conn, stream},
+		Pkey:          peerKeyFromTlsState(conn.ConnectionState().TLS),
+		SecurityLevel: secureLvl,
+	}, nil
+}
+
+func (t QuicTransport) Listen(ctx context.Context, uri url.URL, key ed25519.PrivateKey) (static.TransportListener, error) {
+	config, err := tlsConfigFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientAuth = tls.RequestClientCert
+	config.VerifyPeerCertificate = verifyPeerKey(nil, true)
+	l, err := quic.ListenAddr(uri.Host, config, quicConfig())
+	if err != nil {
+		return nil, err
+	}
+	listener := &quicListener{inner: l}
+	listener.acceptor = newAsyncAcceptor(listener.acceptQuic)
+	return listener, nil
+}
+
+// TransportListener that accepts quic connections
+// and waits for the first stream of each of them.
+// Streams are waited concurrently, so idle connection does not block others.
+type quicListener struct {
+	inner    *quic.Listener
+	acceptor *asyncAcceptor
+}
+
+func (l *quicListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptConn()
+	return conn.Conn, err
+}
+
+// Connections without stream opened in time are closed and skipped.
+func (l *quicListener) AcceptConn() (static.ConnResult, error) {
+	return l.acceptor.accept()
+}
+
+func (l *quicListener) acceptQuic(ctx context.Context) (func(ctx context.Context) (static.ConnResult, error), error) {
+	conn, err := l.inner.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) (static.ConnResult, error) {
+		ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+		defer cancel()
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			conn.CloseWithError(0, "")
+			return static.ConnResult{}, err
+		}
+		return static.ConnResult{
+			Conn:          &quicStreamConn{conn, stream},
+			Pkey:          peerKeyFromTlsState(conn.ConnectionState().TLS),
+			SecurityLevel: static.SECURE_LVL_ENCRYPTED,
+		}, nil
+	}, nil
+}
+
+func (l *quicListener) Close() error {
+	l.acceptor.close()
+	return l.inner.Close()
+}
+
+func (l *quicListener) Addr() net.Addr {
+	return l.inner.Addr()
+}
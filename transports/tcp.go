@@ -30,15 +30,41 @@ const TcpScheme = "tcp"
 
 // Implements tcp yggdrasil transport
 // Compatible with the same named transport in yggdrasil-go
-type TcpTransport struct{}
+//
+// Non-zero fields of Bind override bind options passed to ConnectWithOptions.
+type TcpTransport struct {
+	Bind static.BindOptions
+}
 
 func (t TcpTransport) GetScheme() string {
 	return TcpScheme
 }
 
+// Returns chain of the only proxy or nil for direct connection
+func chainOfProxy(proxy *url.URL) []*url.URL {
+	if proxy == nil {
+		return nil
+	}
+	return []*url.URL{proxy}
+}
+
+// Returns TcpDialer configured by dial options
+func dialerFromOptions(options static.DialOptions) dialers.TcpDialer {
+	return dialers.TcpDialer{
+		Logger:   options.Logger,
+		Resolver: options.Resolver,
+		Bind:     options.Bind,
+	}
+}
+
 func (t TcpTransport) Connect(ctx context.Context, uri url.URL, proxy *url.URL, key ed25519.PrivateKey) (static.ConnResult, error) {
-	dialer := dialers.TcpDialer{}
-	conn, err := dialer.DialContext(ctx, uri, proxy)
+	return t.ConnectWithOptions(ctx, uri, key, static.DialOptions{ProxyChain: chainOfProxy(proxy)})
+}
+
+func (t TcpTransport) ConnectWithOptions(ctx context.Context, uri url.URL, key ed25519.PrivateKey, options static.DialOptions) (static.ConnResult, error) {
+	dialer := dialerFromOptions(options)
+	dialer.Bind = dialer.Bind.Override(t.Bind)
+	conn, err := dialer.DialChainContext(ctx, uri, options.ProxyChain)
 	return static.ConnResult{
 		Conn:          conn,
 		Pkey:          nil,
@@ -0,0 +1,259 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package transports
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"math/big"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Exactly what the name implies
+const TlsScheme = "tls"
+
+// Max time to wait for tls handshake of incoming connection
+const tlsHandshakeTimeout = time.Minute
+
+// Returns list of keys pinned by "key" uri param.
+// Invalid keys are skipped.
+func pinnedKeysFromUri(uri url.URL) []ed25519.PublicKey {
+	keys := make([]ed25519.PublicKey, 0)
+	for _, pubkey := range uri.Query()["key"] {
+		if key, err := hex.DecodeString(pubkey); err == nil && len(key) == ed25519.PublicKeySize {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Generates tls config with self-signed certificate
+// derived from node key in the same way as yggdrasil-go does.
+//
+// If key is nil, new random key will be generated.
+func tlsConfigFromKey(key ed25519.PrivateKey) (*tls.Config, error) {
+	if key == nil {
+		_, spriv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, err
+		}
+		key = spriv
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: hex.EncodeToString(pub),
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour * 24 * 365),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, pub, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{
+			{
+				Certificate: [][]byte{cert},
+				PrivateKey:  key,
+			},
+		},
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+	}, nil
+}
+
+// Returns callback for tls.Config.VerifyPeerCertificate
+// that accepts only ed25519 certificates.
+//
+// If pinned is not empty, only certificates with one of pinned keys are accepted.
+// If optional is true, peer may not send certificate at all.
+func verifyPeerKey(pinned []ed25519.PublicKey, optional bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 && optional {
+			return nil
+		}
+		if len(rawCerts) != 1 {
+			return static.IvalidPeerPublicKey{Text: "tls not exactly 1 cert"}
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return static.IvalidPeerPublicKey{Text: fmt.Sprintf("tls failed to parse cert: %s", err)}
+		}
+		key, ok := cert.PublicKey.(ed25519.PublicKey)
+		if !ok {
+			return static.IvalidPeerPublicKey{Text: "tls wrong cert algorithm"}
+		}
+		if len(pinned) == 0 {
+			return nil
+		}
+		for _, pkey := range pinned {
+			if bytes.Equal(pkey, key) {
+				return nil
+			}
+		}
+		return static.IvalidPeerPublicKey{Text: "tls key does not match pinned key"}
+	}
+}
+
+// Returns ed25519 key from peer certificate or nil.
+func peerKeyFromTlsState(state tls.ConnectionState) ed25519.PublicKey {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	if key, ok := state.PeerCertificates[0].PublicKey.(ed25519.PublicKey); ok {
+		return key
+	}
+	return nil
+}
+
+// Returns SNI for uri.
+// Value of "sni" uri param is used if exists,
+// otherwise uri hostname if it is not an ip address.
+func tlsServerName(uri url.URL) string {
+	if sni := uri.Query().Get("sni"); sni != "" {
+		return sni
+	}
+	if host := uri.Hostname(); net.ParseIP(host) == nil {
+		return host
+	}
+	return ""
+}
+
+// Implements tls yggdrasil transport
+// Compatible with the same named transport in yggdrasil-go
+//
+// Transport key is taken from peer certificate.
+// Connections with keys pinned by "key" uri param
+// are marked as static.SECURE_LVL_ENCRYPTED_AND_VERIFIED.
+type TlsTransport struct{}
+
+func (t TlsTransport) GetScheme() string {
+	return TlsScheme
+}
+
+func (t TlsTransport) Connect(ctx context.Context, uri url.URL, proxy *url.URL, key ed25519.PrivateKey) (static.ConnResult, error) {
+	return t.ConnectWithOptions(ctx, uri, key, static.DialOptions{ProxyChain: chainOfProxy(proxy)})
+}
+
+func (t TlsTransport) ConnectWithOptions(ctx context.Context, uri url.URL, key ed25519.PrivateKey, options static.DialOptions) (static.ConnResult, error) {
+	config, err := tlsConfigFromKey(key)
+	if err != nil {
+		return static.ConnResult{}, err
+	}
+	pinned := pinnedKeysFromUri(uri)
+	config.ServerName = tlsServerName(uri)
+	config.VerifyPeerCertificate = verifyPeerKey(pinned, false)
+	dialer := dialerFromOptions(options)
+	conn, err := dialer.DialChainContext(ctx, uri, options.ProxyChain)
+	if err != nil {
+		return static.ConnResult{}, err
+	}
+	tlsConn := tls.Client(conn, config)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return static.ConnResult{}, err
+	}
+	var secureLvl uint = static.SECURE_LVL_ENCRYPTED
+	if len(pinned) > 0 {
+		secureLvl = static.SECURE_LVL_ENCRYPTED_AND_VERIFIED
+	}
+	return static.ConnResult{
+		Conn:          tlsConn,
+		Pkey:          peerKeyFromTlsState(tlsConn.ConnectionState()),
+		SecurityLevel: secureLvl,
+	}, nil
+}
+
+func (t TlsTransport) Listen(ctx context.Context, uri url.URL, key ed25519.PrivateKey) (static.TransportListener, error) {
+	config, err := tlsConfigFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientAuth = tls.RequestClientCert
+	config.VerifyPeerCertificate = verifyPeerKey(nil, true)
+	l, err := net.Listen(TcpScheme, uri.Host)
+	if err != nil {
+		return nil, err
+	}
+	listener := &tlsListener{inner: l, config: config}
+	listener.acceptor = newAsyncAcceptor(listener.acceptTcp)
+	return listener, nil
+}
+
+// TransportListener that performs tls handshake
+// for each incoming connection.
+// Handshakes run concurrently, so silent peer does not block others.
+type tlsListener struct {
+	inner    net.Listener
+	config   *tls.Config
+	acceptor *asyncAcceptor
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptConn()
+	return conn.Conn, err
+}
+
+// Connections with failed handshake are closed and skipped.
+func (l *tlsListener) AcceptConn() (static.ConnResult, error) {
+	return l.acceptor.accept()
+}
+
+func (l *tlsListener) acceptTcp(ctx context.Context) (func(ctx context.Context) (static.ConnResult, error), error) {
+	conn, err := l.inner.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) (static.ConnResult, error) {
+		tlsConn := tls.Server(conn, l.config)
+		ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+		defer cancel()
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return static.ConnResult{}, err
+		}
+		return static.ConnResult{
+			Conn:          tlsConn,
+			Pkey:          peerKeyFromTlsState(tlsConn.ConnectionState()),
+			SecurityLevel: static.SECURE_LVL_ENCRYPTED,
+		}, nil
+	}, nil
+}
+
+func (l *tlsListener) Close() error {
+	l.acceptor.close()
+	return l.inner.Close()
+}
+
+func (l *tlsListener) Addr() net.Addr {
+	return l.inner.Addr()
+}
@@ -0,0 +1,148 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package transports
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTlsTransport(t *testing.T) {
+	spub, spriv, _ := ed25519.GenerateKey(nil)
+	cpub, cpriv, _ := ed25519.GenerateKey(nil)
+	wrong, _, _ := ed25519.GenerateKey(nil)
+	transport := TlsTransport{}
+	luri, _ := url.Parse("tls://127.0.0.1:0")
+	listener, err := transport.Listen(context.Background(), *luri, spriv)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listener.Close()
+	accepted := make(chan static.ConnResult, 2)
+	go func() {
+		for {
+			conn, err := listener.AcceptConn()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	type Case struct {
+		Query     string
+		Ok        bool
+		SecureLvl uint
+	}
+	cases := []Case{
+		{"", true, static.SECURE_LVL_ENCRYPTED},
+		{"?key=" + hex.EncodeToString(spub), true, static.SECURE_LVL_ENCRYPTED_AND_VERIFIED},
+		{"?key=" + hex.EncodeToString(wrong), false, 0},
+	}
+	for _, c := range cases {
+		uri, _ := url.Parse(fmt.Sprintf("tls://%s%s", listener.Addr().String(), c.Query))
+		res, err := transport.Connect(context.Background(), *uri, nil, cpriv)
+		if !c.Ok {
+			if err == nil {
+				t.Errorf("Connecting with wrong pinned key must raise error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+			continue
+		}
+		if bytes.Compare(res.Pkey, spub) != 0 {
+			t.Errorf("Wrong transport key %s", hex.EncodeToString(res.Pkey))
+		}
+		if res.SecurityLevel != c.SecureLvl {
+			t.Errorf("Wrong security lvl %d %d", res.SecurityLevel, c.SecureLvl)
+		}
+		in := <-accepted
+		if bytes.Compare(in.Pkey, cpub) != 0 {
+			t.Errorf("Wrong client transport key %s", hex.EncodeToString(in.Pkey))
+		}
+		go res.Conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err = in.Conn.Read(buf); err != nil || string(buf) != "ping" {
+			t.Errorf("Wrong data received: %s %s", buf, err)
+		}
+		res.Conn.Close()
+		in.Conn.Close()
+	}
+}
+
+// Silent client must not block handshakes of other clients
+func TestTlsListenerSilentClient(t *testing.T) {
+	_, spriv, _ := ed25519.GenerateKey(nil)
+	_, cpriv, _ := ed25519.GenerateKey(nil)
+	transport := TlsTransport{}
+	luri, _ := url.Parse("tls://127.0.0.1:0")
+	listener, err := transport.Listen(context.Background(), *luri, spriv)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listener.Close()
+	silent, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer silent.Close()
+	accepted := make(chan static.ConnResult, 1)
+	go func() {
+		if conn, err := listener.AcceptConn(); err == nil {
+			accepted <- conn
+		}
+	}()
+	uri, _ := url.Parse(fmt.Sprintf("tls://%s", listener.Addr().String()))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := transport.Connect(ctx, *uri, nil, cpriv)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer res.Conn.Close()
+	select {
+	case in := <-accepted:
+		in.Conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Connection is not accepted")
+	}
+}
+
+func TestTlsServerName(t *testing.T) {
+	cases := map[string]string{
+		"tls://1.2.3.4:1":                   "",
+		"tls://[::1]:1":                     "",
+		"tls://example.com:1":               "example.com",
+		"tls://1.2.3.4:1?sni=example.com":   "example.com",
+		"tls://example.com:1?sni=other.sni": "other.sni",
+	}
+	for raw, sni := range cases {
+		uri, _ := url.Parse(raw)
+		if tlsServerName(*uri) != sni {
+			t.Errorf("Wrong sni for %s: %s", raw, tlsServerName(*uri))
+		}
+	}
+}
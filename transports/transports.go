@@ -26,5 +26,10 @@ import (
 func DEFAULT_TRANSPORTS() []static.Transport {
 	return []static.Transport{
 		TcpTransport{},
+		TlsTransport{},
+		QuicTransport{},
+		WsTransport{},
+		WssTransport{},
+		UnixTransport{},
 	}
 }
@@ -0,0 +1,139 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package transports
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Exactly what the name implies
+const UnixScheme = "unix"
+
+// Returns socket path from uri.
+//
+// Paths like "unix:///@name" are mapped to
+// abstract namespace socket "@name".
+func unixSocketPath(uri url.URL) (string, error) {
+	path := uri.Path
+	if uri.Opaque != "" {
+		path = uri.Opaque
+	}
+	if strings.HasPrefix(path, "/@") {
+		path = path[1:]
+	}
+	if path == "" || path == "@" {
+		return "", static.InvalidUriError{Err: "unix socket path is empty"}
+	}
+	return path, nil
+}
+
+// Implements unix socket yggdrasil transport
+//
+// Socket path is taken from uri path,
+// "unix:///@name" is used for abstract namespace sockets.
+//
+// Local connections are not encrypted,
+// but can not be intercepted from network,
+// so SecurityLevel is configurable.
+// DEFAULT_TRANSPORTS use static.SECURE_LVL_UNSECURE,
+// pass transport with higher level to trust local sockets more.
+// Credentials of peer process are returned in ConnResult.PeerCred
+// on platforms with SO_PEERCRED support.
+//
+// Unix sockets can not be used with any proxy, so
+// static.InapplicableProxyTypeError is returned
+// if proxy was selected.
+type UnixTransport struct {
+	SecurityLevel uint
+}
+
+func (t UnixTransport) GetScheme() string {
+	return UnixScheme
+}
+
+func (t UnixTransport) Connect(ctx context.Context, uri url.URL, proxy *url.URL, key ed25519.PrivateKey) (static.ConnResult, error) {
+	if proxy != nil {
+		return static.ConnResult{}, static.InapplicableProxyTypeError{
+			Transport: UnixScheme,
+			Proxy:     *proxy,
+		}
+	}
+	path, err := unixSocketPath(uri)
+	if err != nil {
+		return static.ConnResult{}, err
+	}
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, UnixScheme, path)
+	if err != nil {
+		return static.ConnResult{}, err
+	}
+	return static.ConnResult{
+		Conn:          conn,
+		Pkey:          nil,
+		SecurityLevel: t.SecurityLevel,
+		PeerCred:      peerCredFromConn(conn),
+	}, nil
+}
+
+func (t UnixTransport) Listen(ctx context.Context, uri url.URL, key ed25519.PrivateKey) (static.TransportListener, error) {
+	path, err := unixSocketPath(uri)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen(UnixScheme, path)
+	if err != nil {
+		return nil, err
+	}
+	return &unixListener{l, t.SecurityLevel}, nil
+}
+
+// TransportListener that returns peer credentials
+// with each accepted connection.
+type unixListener struct {
+	inner         net.Listener
+	securityLevel uint
+}
+
+func (l *unixListener) Accept() (net.Conn, error) {
+	return l.inner.Accept()
+}
+
+func (l *unixListener) AcceptConn() (static.ConnResult, error) {
+	conn, err := l.inner.Accept()
+	if err != nil {
+		return static.ConnResult{}, err
+	}
+	return static.ConnResult{
+		Conn:          conn,
+		Pkey:          nil,
+		SecurityLevel: l.securityLevel,
+		PeerCred:      peerCredFromConn(conn),
+	}, nil
+}
+
+func (l *unixListener) Close() error {
+	return l.inner.Close()
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return l.inner.Addr()
+}
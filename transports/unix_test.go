@@ -0,0 +1,120 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package transports
+
+import (
+	"context"
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func testUnixTransport(t *testing.T, rawUri string) {
+	transport := UnixTransport{SecurityLevel: static.SECURE_LVL_ENCRYPTED}
+	uri, _ := url.Parse(rawUri)
+	listener, err := transport.Listen(context.Background(), *uri, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listener.Close()
+	accepted := make(chan static.ConnResult, 1)
+	go func() {
+		conn, err := listener.AcceptConn()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	res, err := transport.Connect(context.Background(), *uri, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer res.Conn.Close()
+	in := <-accepted
+	defer in.Conn.Close()
+	for _, conn := range []static.ConnResult{res, in} {
+		if conn.SecurityLevel != static.SECURE_LVL_ENCRYPTED {
+			t.Errorf("Wrong security lvl %d", conn.SecurityLevel)
+		}
+		if runtime.GOOS != "linux" {
+			continue
+		}
+		if conn.PeerCred == nil {
+			t.Errorf("Peer credentials must be available")
+		} else if conn.PeerCred.Uid != uint32(os.Getuid()) || conn.PeerCred.Pid != int32(os.Getpid()) {
+			t.Errorf("Wrong peer credentials %v", *conn.PeerCred)
+		}
+	}
+	go res.Conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err = in.Conn.Read(buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Wrong data received: %s %s", buf, err)
+	}
+}
+
+func TestUnixTransportFilesystem(t *testing.T) {
+	testUnixTransport(t, fmt.Sprintf("unix://%s", filepath.Join(t.TempDir(), "ygg.sock")))
+}
+
+func TestUnixTransportAbstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract unix sockets are linux only")
+	}
+	testUnixTransport(t, fmt.Sprintf("unix:///@ytl-test-%d", os.Getpid()))
+}
+
+func TestUnixSocketPath(t *testing.T) {
+	cases := map[string]string{
+		"unix:///var/run/ygg.sock": "/var/run/ygg.sock",
+		"unix:///@ygg":             "@ygg",
+		"unix:@ygg":                "@ygg",
+		"unix://":                  "",
+		"unix:///@":                "",
+	}
+	for raw, path := range cases {
+		uri, _ := url.Parse(raw)
+		res, err := unixSocketPath(*uri)
+		if path == "" {
+			if _, ok := err.(static.InvalidUriError); !ok {
+				t.Errorf("Must raise InvalidUriError for %s", raw)
+			}
+		} else if res != path {
+			t.Errorf("Wrong path for %s: %s", raw, res)
+		}
+	}
+}
+
+func TestUnixTransportProxy(t *testing.T) {
+	uri, _ := url.Parse("unix:///tmp/ygg.sock")
+	proxy, _ := url.Parse("socks://127.0.0.1:9050")
+	_, err := UnixTransport{}.Connect(context.Background(), *uri, proxy, nil)
+	if _, ok := err.(static.InapplicableProxyTypeError); !ok {
+		t.Fatalf("Must raise InapplicableProxyTypeError: %s", err)
+	}
+}
+
+// Plaintext local sockets must not displace verified links by default
+func TestUnixTransportDefaultSecurityLevel(t *testing.T) {
+	for _, transport := range DEFAULT_TRANSPORTS() {
+		if unix, ok := transport.(UnixTransport); ok && unix.SecurityLevel != static.SECURE_LVL_UNSECURE {
+			t.Fatalf("Wrong default security level %d", unix.SecurityLevel)
+		}
+	}
+}
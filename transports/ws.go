@@ -0,0 +1,277 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package transports
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"github.com/coder/websocket"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Exactly what the name implies
+const WsScheme = "ws"
+
+// Exactly what the name implies
+const WssScheme = "wss"
+
+// Websocket subprotocol used by yggdrasil-go
+const wsSubprotocol = "ygg-ws"
+
+// Opens websocket connection to uri via TcpDialer
+// and wraps it to byte-stream [net.Conn].
+func wsConnect(ctx context.Context, uri url.URL, options static.DialOptions) (net.Conn, error) {
+	peer, err := static.PeerURIFromURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	// Ytl params (password especially) must not be sent to server
+	wsUri := uri
+	wsUri.RawQuery = peer.Extra.Encode()
+	dialer := dialerFromOptions(options)
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				// Query is kept for bind options
+				return dialer.DialChainContext(
+					ctx,
+					url.URL{Scheme: TcpScheme, Host: address, RawQuery: uri.RawQuery},
+					options.ProxyChain,
+				)
+			},
+			TLSClientConfig: &tls.Config{
+				ServerName: uri.Query().Get("sni"),
+				// Verified by VerifyPeerCertificate
+				InsecureSkipVerify:    true,
+				VerifyPeerCertificate: verifyWssPeer(uri),
+			},
+		},
+	}
+	conn, _, err := websocket.Dial(ctx, wsUri.String(), &websocket.DialOptions{
+		HTTPClient:   client,
+		Subprotocols: []string{wsSubprotocol},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Context of NetConn controls whole connection lifetime, not only dialing
+	return websocket.NetConn(context.Background(), conn, websocket.MessageBinary), nil
+}
+
+// Returns callback for tls.Config.VerifyPeerCertificate
+// that accepts certificates derived from node keys (like TlsTransport does)
+// and certificates valid for uri host by system roots.
+func verifyWssPeer(uri url.URL) func([][]byte, [][]*x509.Certificate) error {
+	verifyKey := verifyPeerKey(nil, false)
+	serverName := uri.Query().Get("sni")
+	if serverName == "" {
+		serverName = uri.Hostname()
+	}
+	return func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if verifyKey(rawCerts, chains) == nil {
+			return nil
+		}
+		intermediates := x509.NewCertPool()
+		var leaf *x509.Certificate = nil
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				leaf = cert
+			} else {
+				intermediates.AddCert(cert)
+			}
+		}
+		if leaf == nil {
+			return static.IvalidPeerPublicKey{Text: "tls no cert"}
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			DNSName:       serverName,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+}
+
+// Serves websocket upgrades on uri path
+// (or on any path if uri path is empty)
+// and returns them via TransportListener interface.
+func wsListen(uri url.URL, listener net.Listener, securityLevel uint) static.TransportListener {
+	l := &wsListener{
+		inner:         listener,
+		path:          uri.Path,
+		securityLevel: securityLevel,
+		conns:         make(chan net.Conn),
+		closed:        make(chan struct{}),
+	}
+	l.server = &http.Server{
+		Handler: l,
+		// Idle clients must not hold sockets before upgrade
+		ReadHeaderTimeout: tlsHandshakeTimeout,
+	}
+	go l.server.Serve(listener)
+	return l
+}
+
+// TransportListener that accepts websocket upgrades.
+type wsListener struct {
+	inner         net.Listener
+	server        *http.Server
+	path          string
+	securityLevel uint
+	conns         chan net.Conn
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+func (l *wsListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if l.path != "" && l.path != "/" && r.URL.Path != l.path {
+		http.NotFound(w, r)
+		return
+	}
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols: []string{wsSubprotocol},
+	})
+	if err != nil {
+		return
+	}
+	if c.Subprotocol() != wsSubprotocol {
+		c.Close(websocket.StatusPolicyViolation, "client must speak the ygg-ws subprotocol")
+		return
+	}
+	conn := websocket.NetConn(context.Background(), c, websocket.MessageBinary)
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptConn()
+	return conn.Conn, err
+}
+
+func (l *wsListener) AcceptConn() (static.ConnResult, error) {
+	select {
+	case conn := <-l.conns:
+		return static.ConnResult{
+			Conn:          conn,
+			Pkey:          nil,
+			SecurityLevel: l.securityLevel,
+		}, nil
+	case <-l.closed:
+		return static.ConnResult{}, net.ErrClosed
+	}
+}
+
+// Already accepted connections stay open.
+func (l *wsListener) Close() (err error) {
+	err = net.ErrClosed
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.server.Close()
+	})
+	return
+}
+
+func (l *wsListener) Addr() net.Addr {
+	return l.inner.Addr()
+}
+
+// Implements ws yggdrasil transport
+// Compatible with the same named transport in yggdrasil-go
+//
+// Listener serves upgrades on uri path,
+// or on any path if uri path is empty.
+type WsTransport struct{}
+
+func (t WsTransport) GetScheme() string {
+	return WsScheme
+}
+
+func (t WsTransport) Connect(ctx context.Context, uri url.URL, proxy *url.URL, key ed25519.PrivateKey) (static.ConnResult, error) {
+	return t.ConnectWithOptions(ctx, uri, key, static.DialOptions{ProxyChain: chainOfProxy(proxy)})
+}
+
+func (t WsTransport) ConnectWithOptions(ctx context.Context, uri url.URL, key ed25519.PrivateKey, options static.DialOptions) (static.ConnResult, error) {
+	conn, err := wsConnect(ctx, uri, options)
+	return static.ConnResult{
+		Conn:          conn,
+		Pkey:          nil,
+		SecurityLevel: static.SECURE_LVL_UNSECURE,
+	}, err
+}
+
+func (t WsTransport) Listen(ctx context.Context, uri url.URL, key ed25519.PrivateKey) (static.TransportListener, error) {
+	l, err := net.Listen(TcpScheme, uri.Host)
+	if err != nil {
+		return nil, err
+	}
+	return wsListen(uri, l, static.SECURE_LVL_UNSECURE), nil
+}
+
+// Implements wss yggdrasil transport
+// Compatible with the same named transport in yggdrasil-go
+//
+// Server certificate is accepted if it is derived from node key
+// (so ytl and yggdrasil-go listeners are reachable)
+// or if it is valid in the usual way,
+// because wss peers are usually placed behind https reverse proxies.
+// Value of "sni" uri param overrides server name.
+//
+// Listener uses self-signed certificate derived from node key
+// (like TlsTransport) and serves upgrades on uri path,
+// or on any path if uri path is empty.
+type WssTransport struct{}
+
+func (t WssTransport) GetScheme() string {
+	return WssScheme
+}
+
+func (t WssTransport) Connect(ctx context.Context, uri url.URL, proxy *url.URL, key ed25519.PrivateKey) (static.ConnResult, error) {
+	return t.ConnectWithOptions(ctx, uri, key, static.DialOptions{ProxyChain: chainOfProxy(proxy)})
+}
+
+func (t WssTransport) ConnectWithOptions(ctx context.Context, uri url.URL, key ed25519.PrivateKey, options static.DialOptions) (static.ConnResult, error) {
+	conn, err := wsConnect(ctx, uri, options)
+	return static.ConnResult{
+		Conn:          conn,
+		Pkey:          nil,
+		SecurityLevel: static.SECURE_LVL_ENCRYPTED,
+	}, err
+}
+
+func (t WssTransport) Listen(ctx context.Context, uri url.URL, key ed25519.PrivateKey) (static.TransportListener, error) {
+	config, err := tlsConfigFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen(TcpScheme, uri.Host)
+	if err != nil {
+		return nil, err
+	}
+	return wsListen(uri, tls.NewListener(l, config), static.SECURE_LVL_ENCRYPTED), nil
+}
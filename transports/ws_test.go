@@ -0,0 +1,126 @@
+// This file is part of Ytl.
+//
+// Ytl is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Lesser General Public
+// License as published by the Free Software Foundation; either
+// version 3 of the License, or (at your option) any later version.
+//
+// Ytl is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this program; if not, write to the Free Software Foundation,
+// Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package transports
+
+import (
+	"context"
+	"fmt"
+	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func testWsTransport(t *testing.T, transport static.Transport, secureLvl uint) {
+	scheme := transport.GetScheme()
+	luri, _ := url.Parse(scheme + "://127.0.0.1:0/ygg")
+	listener, err := transport.Listen(context.Background(), *luri, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listener.Close()
+	accepted := make(chan static.ConnResult, 1)
+	go func() {
+		for {
+			conn, err := listener.AcceptConn()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	uri, _ := url.Parse(fmt.Sprintf("%s://%s/other", scheme, listener.Addr().String()))
+	if _, err = transport.Connect(context.Background(), *uri, nil, nil); err == nil {
+		t.Errorf("Upgrade on wrong path must raise error")
+	}
+	uri, _ = url.Parse(fmt.Sprintf("%s://%s/ygg", scheme, listener.Addr().String()))
+	res, err := transport.Connect(context.Background(), *uri, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if res.SecurityLevel != secureLvl {
+		t.Errorf("Wrong security lvl %d", res.SecurityLevel)
+	}
+	in := <-accepted
+	go res.Conn.Write([]byte("pingpong"))
+	// Connection is a byte-stream, so message can be read partially
+	for _, part := range []string{"ping", "pong"} {
+		buf := make([]byte, 4)
+		if _, err = in.Conn.Read(buf); err != nil || string(buf) != part {
+			t.Errorf("Wrong data received: %s %s", buf, err)
+		}
+	}
+	// Close handshake needs both sides to be closed
+	go res.Conn.Close()
+	in.Conn.Close()
+}
+
+func TestWsTransport(t *testing.T) {
+	testWsTransport(t, WsTransport{}, static.SECURE_LVL_UNSECURE)
+}
+
+func TestWssTransport(t *testing.T) {
+	testWsTransport(t, WssTransport{}, static.SECURE_LVL_ENCRYPTED)
+}
+
+func TestVerifyWssPeer(t *testing.T) {
+	uri, _ := url.Parse("wss://127.0.0.1:1")
+	verify := verifyWssPeer(*uri)
+	config, _ := tlsConfigFromKey(nil)
+	if err := verify(config.Certificates[0].Certificate, nil); err != nil {
+		t.Errorf("Certificate of node key must be accepted: %s", err)
+	}
+	// Signed by unknown authority
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	if err := verify([][]byte{srv.Certificate().Raw}, nil); err == nil {
+		t.Errorf("Untrusted certificate must be rejected")
+	}
+}
+
+func TestWsConnectStripsPeerParams(t *testing.T) {
+	queries := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.RawQuery
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	uri, _ := url.Parse(srv.URL + "/ygg?password=secret&priority=1&sni=host&custom=1")
+	uri.Scheme = WsScheme
+	if _, err := (WsTransport{}).Connect(context.Background(), *uri, nil, nil); err == nil {
+		t.Fatalf("Upgrade must fail")
+	}
+	if query := <-queries; query != "custom=1" {
+		t.Errorf("Only unknown params must be sent, got %s", query)
+	}
+}
+
+func TestWsListenerClose(t *testing.T) {
+	luri, _ := url.Parse("ws://127.0.0.1:0")
+	listener, err := WsTransport{}.Listen(context.Background(), *luri, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	listener.Close()
+	if _, err = listener.Accept(); err == nil {
+		t.Fatalf("Accept on closed listener must raise error")
+	}
+	if listener.Close() == nil {
+		t.Fatalf("Second close must raise error")
+	}
+}
@@ -22,44 +22,62 @@ import (
 	"github.com/Yggdrasil-Unofficial/ytl/addr"
 	"github.com/Yggdrasil-Unofficial/ytl/static"
 	"io"
+	"log/slog"
 	"net"
+	"net/url"
+	"sort"
+	"sync"
 	"time"
 )
 
 // Parse handshake package with meta info.
+// Both fixed-size and TLV (since yggdrasil 0.5) layouts are supported.
+//
+// Only protocol versions accepted by config are allowed.
+// TLV pkg signature is checked with optional config password.
+// Config may be nil.
+//
 // Returns parsed data, or error.
-func internalParseMetaPackage(conn net.Conn) (
+func internalParseMetaPackage(conn net.Conn, config *HandshakeConfig) (
 	err error,
 	version *static.ProtoVersion,
 	pkey ed25519.PublicKey,
 	buf []byte,
 ) {
-	buf = make([]byte, len(static.META_HEADER())+2+ed25519.PublicKeySize)
-	_, err = io.ReadFull(conn, buf)
+	header := make([]byte, metaPeekSize)
+	_, err = io.ReadFull(conn, header)
 	if err != nil {
 		return
 	}
-	if bytes.Compare(static.META_HEADER(), buf[:len(static.META_HEADER())]) != 0 {
+	if bytes.Compare(static.META_HEADER(), header[:len(static.META_HEADER())]) != 0 {
 		// Unknown proto
 		err = static.UnknownProtoError{}
 		return
 	}
-	version = &static.ProtoVersion{
-		Major: buf[len(static.META_HEADER())],
-		Minor: buf[len(static.META_HEADER())+1],
+	codec := metaCodecForHeader(header)
+	pkg, err := codec.read(conn, header)
+	if err != nil {
+		return
 	}
-	target_version := static.PROTO_VERSION()
-	if version.Major != target_version.Major || version.Minor != target_version.Minor {
+	version = &pkg.version
+	buf = pkg.raw
+	if !config.accepts(pkg.version) || metaCodecForVersion(pkg.version) != codec {
 		// Unknown proto version
+		expected := codec.version()
+		if !config.accepts(expected) {
+			versions := config.versions()
+			expected = versions[len(versions)-1]
+		}
 		err = static.UnknownProtoVersionError{
-			Expected: static.PROTO_VERSION(),
+			Expected: expected,
 			Received: *version,
 		}
 		return
 	}
-	key_raw := buf[len(buf)-ed25519.PublicKeySize:]
-	pkey = make(ed25519.PublicKey, ed25519.PublicKeySize)
-	copy(pkey, key_raw)
+	if err = codec.verify(pkg, config.password()); err != nil {
+		return
+	}
+	pkey = pkg.pkey
 	return
 }
 
@@ -67,7 +85,7 @@ func internalParseMetaPackage(conn net.Conn) (
 // Returns parsed data, or error.
 // Close connection if handshake package
 // does not received until timeout.
-func parseMetaPackage(conn net.Conn, timeout time.Duration) (
+func parseMetaPackage(conn net.Conn, timeout time.Duration, config *HandshakeConfig) (
 	err error,
 	version *static.ProtoVersion,
 	pkey ed25519.PublicKey,
@@ -81,7 +99,7 @@ func parseMetaPackage(conn net.Conn, timeout time.Duration) (
 	}
 	ret := make(chan result, 1)
 	go func() {
-		err, version, pkey, buf := internalParseMetaPackage(conn)
+		err, version, pkey, buf := internalParseMetaPackage(conn, config)
 		ret <- result{err, version, pkey, buf}
 	}()
 	select {
@@ -98,6 +116,91 @@ func parseMetaPackage(conn net.Conn, timeout time.Duration) (
 	}
 }
 
+// HandshakeConfig contains params of own handshake pkg
+// that YggConn sends to other node
+// and params for checking received one.
+type HandshakeConfig struct {
+	// Private key of current node.
+	// Its public part is sent in handshake pkg.
+	// If it is nil, own handshake pkg is not sent.
+	Key ed25519.PrivateKey
+	// Protocol versions accepted from other node.
+	// If it is empty, all SupportedProtoVersions are accepted.
+	Versions []static.ProtoVersion
+	// Version of sent handshake pkg.
+	// If it is nil, the newest of accepted versions is used.
+	// Received pkg must have the same version.
+	//
+	// Ignored if Responder is true.
+	Version *static.ProtoVersion
+	// If true, own handshake pkg is sent only after
+	// handshake pkg of other node was received
+	// and has the same protocol version.
+	//
+	// It allows to serve nodes with different versions.
+	Responder bool
+	// Optional password used to sign sent TLV handshake pkg
+	// and to check received one.
+	// Up to 64 bytes.
+	Password []byte
+	// Link priority sent in TLV handshake pkg.
+	Priority uint8
+	// Max time to wait for handshake pkg of other node.
+	// If it is zero, DEFAULT_HANDSHAKE_TIMEOUT is used.
+	Timeout time.Duration
+}
+
+// Returns default max time to wait for handshake pkg
+func DEFAULT_HANDSHAKE_TIMEOUT() time.Duration {
+	return time.Minute
+}
+
+// Returns max time to wait for handshake pkg.
+func (h *HandshakeConfig) timeout() time.Duration {
+	if h == nil || h.Timeout <= 0 {
+		return DEFAULT_HANDSHAKE_TIMEOUT()
+	}
+	return h.Timeout
+}
+
+// Returns accepted versions from oldest to newest.
+func (h *HandshakeConfig) versions() []static.ProtoVersion {
+	if h == nil || len(h.Versions) == 0 {
+		return SupportedProtoVersions()
+	}
+	versions := make([]static.ProtoVersion, len(h.Versions))
+	copy(versions, h.Versions)
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Less(versions[j]) })
+	return versions
+}
+
+// Returns true if handshake pkg of other node may have this version.
+func (h *HandshakeConfig) accepts(version static.ProtoVersion) bool {
+	for _, v := range h.versions() {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns version of handshake pkg sent first.
+func (h *HandshakeConfig) version() static.ProtoVersion {
+	if h.Version != nil {
+		return *h.Version
+	}
+	versions := h.versions()
+	return versions[len(versions)-1]
+}
+
+// Returns password or nil if config is nil.
+func (h *HandshakeConfig) password() []byte {
+	if h == nil {
+		return nil
+	}
+	return h.Password
+}
+
 // Wraper that represents connection with
 // other yggdrasil node.
 //
@@ -109,12 +212,15 @@ type YggConn struct {
 	allowList        *static.AllowList
 	secureTranport   uint
 	extraReadBuffChn chan []byte
-	err              error
+	err              *connErr
 	dm               *DeduplicationManager
-	closefn          func()
+	closed           chan struct{}
 	pVersion         chan *static.ProtoVersion
 	otherPublicKey   chan ed25519.PublicKey
 	isClosed         chan bool
+	handshake        *HandshakeConfig
+	metaSent         chan struct{}
+	state            *connState
 }
 
 // Wraps regular net connection to YggConn.
@@ -133,72 +239,222 @@ func ConnToYggConn(
 	allow *static.AllowList,
 	secureTranport uint,
 	dm *DeduplicationManager,
+) *YggConn {
+	return ConnToYggConnWithHandshake(conn, transport_key, allow, secureTranport, dm, nil)
+}
+
+// Wraps regular net connection to YggConn
+// like ConnToYggConn does.
+//
+// If handshake is not nil, YggConn also sends own handshake pkg
+// and does not pass received one to reader,
+// so connection becomes ready-to-use yggdrasil link.
+// Any data written before handshake pkg was sent
+// waits until it will be done.
+func ConnToYggConnWithHandshake(
+	conn net.Conn,
+	transport_key ed25519.PublicKey,
+	allow *static.AllowList,
+	secureTranport uint,
+	dm *DeduplicationManager,
+	handshake *HandshakeConfig,
+) *YggConn {
+	direction := static.DIRECTION_OUTBOUND
+	if handshake != nil && handshake.Responder {
+		direction = static.DIRECTION_INBOUND
+	}
+	return newYggConn(
+		conn,
+		transport_key,
+		allow,
+		dm,
+		handshake,
+		newConnState(url.URL{}, nil, direction, secureTranport, nil, nil),
+	)
+}
+
+func newYggConn(
+	conn net.Conn,
+	transport_key ed25519.PublicKey,
+	allow *static.AllowList,
+	dm *DeduplicationManager,
+	handshake *HandshakeConfig,
+	state *connState,
 ) *YggConn {
 	if conn == nil {
 		return nil
 	}
+	secureTranport := state.info.SecurityLevel
 	isClosed := make(chan bool, 1)
 	isClosed <- false
 	ret := YggConn{
-		conn,
+		&countingConn{conn, state},
 		transport_key,
 		allow,
 		secureTranport,
 		make(chan []byte, 1),
-		nil,
+		&connErr{},
 		dm,
-		func() {},
+		make(chan struct{}),
 		make(chan *static.ProtoVersion, 1),
 		make(chan ed25519.PublicKey, 1),
 		isClosed,
+		handshake,
+		make(chan struct{}),
+		state,
 	}
 	go ret.middleware()
 	return &ret
 }
 
-func (y *YggConn) setErr(err error) {
-	if y.err == nil {
-		y.err = err
+// First error of connection, shared by YggConn copies
+type connErr struct {
+	mutex sync.Mutex
+	err   error
+}
+
+// Stores err if no error was stored before
+func (e *connErr) set(err error) {
+	if e == nil {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.err == nil {
+		e.err = err
 	}
+}
+
+func (e *connErr) get() error {
+	if e == nil {
+		return nil
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.err
+}
+
+func (y *YggConn) setErr(err error) {
+	y.err.set(err)
 	y.Close()
 }
 
+// Returns observer passed by ConnManager or nil
+func (y *YggConn) observer() Observer {
+	if y.state == nil {
+		return nil
+	}
+	return y.state.observer
+}
+
+// Closes connection with error and notifies observer
+func (y *YggConn) reject(err error) {
+	if y.state != nil {
+		y.state.log().Info("Connection is rejected", "error", err)
+	}
+	if o := y.observer(); o != nil {
+		o.OnReject(y.state.snapshot(), err)
+	}
+	y.setErr(err)
+}
+
 func (y *YggConn) checkAddr() bool {
-	laddr, _, _ := net.SplitHostPort(y.innerConn.LocalAddr().String())
-	raddr, _, _ := net.SplitHostPort(y.innerConn.RemoteAddr().String())
-	if err := addr.CheckAddr(net.ParseIP(laddr)); err != nil {
-		y.setErr(err)
+	if err := addr.CheckNetAddr(y.innerConn.LocalAddr()); err != nil {
+		y.reject(err)
 		return true
 	}
-	if err := addr.CheckAddr(net.ParseIP(raddr)); err != nil {
-		y.setErr(err)
+	if err := addr.CheckNetAddr(y.innerConn.RemoteAddr()); err != nil {
+		y.reject(err)
 		return true
 	}
 	return false
 }
 
+// Returns true if YggConn performs the whole handshake by itself.
+func (y *YggConn) isHandshaking() bool {
+	return y.handshake != nil && y.handshake.Key != nil
+}
+
+// Sends own handshake pkg with passed protocol version.
+func (y *YggConn) sendMeta(version static.ProtoVersion) {
+	defer close(y.metaSent)
+	codec := metaCodecForVersion(version)
+	if codec == nil {
+		y.setErr(static.UnknownProtoVersionError{
+			Expected: y.handshake.version(),
+			Received: version,
+		})
+		return
+	}
+	buf, err := codec.build(y.handshake.Key, y.handshake.Password, y.handshake.Priority)
+	if err != nil {
+		y.setErr(err)
+		return
+	}
+	if _, err := y.innerConn.Write(buf); err != nil {
+		y.setErr(err)
+	}
+}
+
 func (y *YggConn) middleware() {
+	if y.state != nil {
+		defer y.state.finishHandshake()
+	}
 	var extraReadBuff []byte = nil
 	defer func() { y.extraReadBuffChn <- extraReadBuff }()
 	// We must do this in middleware and not in constructor because it may spend much time
 	if y.checkAddr() {
+		close(y.metaSent)
+		// Unblock getters, error is already set
+		y.pVersion <- nil
+		y.otherPublicKey <- nil
 		return
 	}
-	err, version, pkey, buf := parseMetaPackage(y.innerConn, time.Minute)
+	responding := y.isHandshaking() && y.handshake.Responder
+	var sentVersion *static.ProtoVersion = nil
+	if y.isHandshaking() && !responding {
+		v := y.handshake.version()
+		sentVersion = &v
+		// Other node may not read anything before sending own handshake pkg
+		go y.sendMeta(v)
+	} else if !responding {
+		close(y.metaSent)
+	}
+	err, version, pkey, buf := parseMetaPackage(y.innerConn, y.handshake.timeout(), y.handshake)
+	if err == nil && sentVersion != nil && *version != *sentVersion {
+		err = static.UnknownProtoVersionError{
+			Expected: *sentVersion,
+			Received: *version,
+		}
+		pkey = nil
+	}
+	if responding {
+		if err == nil {
+			go y.sendMeta(*version)
+		} else {
+			close(y.metaSent)
+		}
+	}
+	if err != nil {
+		// Error must be available before getters are unblocked
+		y.reject(err)
+		version = nil
+	} else {
+		y.state.setPeer(version, pkey)
+	}
 	y.pVersion <- version
 	y.otherPublicKey <- pkey
 	if len(buf) == 0 {
 		buf = nil
 	}
 	if err != nil {
-		y.setErr(err)
 		return
 	}
 	// Check if node key equal transport key
 	if y.transport_key != nil {
 		if bytes.Compare(y.transport_key, pkey) != 0 {
 			// Invalid transport key
-			y.setErr(static.TransportSecurityCheckError{
+			y.reject(static.TransportSecurityCheckError{
 				Expected: y.transport_key,
 				Received: pkey,
 			})
@@ -208,7 +464,7 @@ func (y *YggConn) middleware() {
 	if y.allowList != nil {
 		if !y.allowList.IsAllow(pkey) {
 			// TODO Write more human readable error text
-			y.setErr(static.IvalidPeerPublicKey{
+			y.reject(static.IvalidPeerPublicKey{
 				Text: "Key received from the peer is not in the allow list",
 			})
 			return
@@ -216,26 +472,46 @@ func (y *YggConn) middleware() {
 	}
 	if y.dm != nil {
 		closefunc := y.dm.Check(pkey, y.secureTranport, func() {
-			y.setErr(static.ConnClosedByDeduplicatorError{})
+			select {
+			case <-y.closed:
+			default:
+				y.state.log().Info("Connection is displaced by more secure duplicate")
+				if o := y.observer(); o != nil {
+					o.OnDuplicateEvicted(y.state.snapshot())
+				}
+				y.setErr(static.ConnClosedByDeduplicatorError{})
+			}
 		})
 		if closefunc == nil {
-			y.setErr(static.ConnClosedByDeduplicatorError{})
+			y.reject(static.ConnClosedByDeduplicatorError{})
 			return
 		}
-		y.closefn = closefunc
+		if !y.state.addCloseFn(closefunc) {
+			// Connection was closed while it was checking
+			closefunc()
+		}
+	}
+	// Handshake pkg is passed to reader only if it must handle handshake by itself
+	if !y.isHandshaking() {
+		extraReadBuff = buf
+	}
+	y.state.log().Info("Handshake is completed", "version", version.String())
+	if o := y.observer(); o != nil {
+		o.OnHandshake(y.state.snapshot())
 	}
-	//
-	extraReadBuff = buf
 }
 
 // Returns version of yggdrasil protocol
 // using for this connection if handshake pkg
 // was successfully received and parsed.
+//
+// If YggConn sends own handshake pkg,
+// it is the negotiated version used by both nodes.
 func (y *YggConn) GetVer() (*static.ProtoVersion, error) {
 	v := <-y.pVersion
 	defer func() { y.pVersion <- v }()
 	if v == nil {
-		return nil, y.err
+		return nil, y.err.get()
 	}
 	return v, nil
 }
@@ -247,29 +523,61 @@ func (y *YggConn) GetPublicKey() (ed25519.PublicKey, error) {
 	k := <-y.otherPublicKey
 	defer func() { y.otherPublicKey <- k }()
 	if k == nil {
-		return nil, y.err
+		return nil, y.err.get()
 	}
 	return k, nil
 }
 
 func (y *YggConn) Close() (err error) {
-	closed := <-y.isClosed
-	defer func() { y.isClosed <- closed }()
-	closed = true
-	if y.closefn != nil && !closed {
-		y.closefn()
-		y.closefn = func() {}
+	wasClosed := <-y.isClosed
+	if !wasClosed {
+		close(y.closed)
+	}
+	y.isClosed <- true
+	if y.state != nil {
+		for _, closefn := range y.state.takeCloseFns() {
+			closefn()
+		}
 	}
 	err = y.innerConn.Close()
-	if y.err != nil {
-		err = y.err
+	if y.state != nil && !wasClosed {
+		info := y.state.snapshot()
+		y.state.log().Debug(
+			"Connection is closed",
+			"bytes_in", info.BytesIn,
+			"bytes_out", info.BytesOut,
+		)
+		if o := y.observer(); o != nil {
+			o.OnClose(info)
+		}
+	}
+	if connErr := y.err.get(); connErr != nil {
+		err = connErr
 	}
 	return err
 }
 
+// Returns snapshot of connection state.
+func (y *YggConn) Snapshot() ConnSnapshot {
+	if y.state == nil {
+		return ConnSnapshot{}
+	}
+	return y.state.snapshot()
+}
+
+// Returns channel that will be closed after connection is closed.
+func (y *YggConn) Closed() <-chan struct{} {
+	return y.closed
+}
+
 func (y *YggConn) Read(b []byte) (n int, err error) {
 	buf := <-y.extraReadBuffChn
 	defer func() { y.extraReadBuffChn <- buf }()
+	defer func() {
+		if n > 0 && y.state != nil {
+			y.state.packetsIn.Add(1)
+		}
+	}()
 	if buf != nil {
 		err = nil
 		n = copy(b, buf)
@@ -281,14 +589,19 @@ func (y *YggConn) Read(b []byte) (n int, err error) {
 		return
 	}
 	n, err = y.innerConn.Read(b)
-	if y.err != nil {
-		err = y.err
+	if connErr := y.err.get(); connErr != nil {
+		err = connErr
 	}
 	return
 }
 
 func (y *YggConn) Write(b []byte) (n int, err error) {
-	return y.innerConn.Write(b)
+	<-y.metaSent
+	n, err = y.innerConn.Write(b)
+	if n > 0 && y.state != nil {
+		y.state.packetsOut.Add(1)
+	}
+	return
 }
 
 func (y *YggConn) LocalAddr() net.Addr {
@@ -316,22 +629,136 @@ type YggListener struct {
 	inner_listener static.TransportListener
 	dm             *DeduplicationManager
 	allowList      *static.AllowList
+	handshake      *HandshakeConfig
+	uri            url.URL
+	registry       *connRegistry
+	listenerId     uint64
+	observer       Observer
+	logger         *slog.Logger
+	limiter        *handshakeLimiter
 }
 
 // Accept waits for and returns the next connection to the listener.
+//
+// Connections exceeding limits of pending handshakes
+// are closed immediately and are not returned.
 func (y *YggListener) Accept() (ygg YggConn, err error) {
-	conn, err := y.inner_listener.AcceptConn()
-	if err != nil {
+	var conn static.ConnResult
+	var release func()
+	for {
+		conn, err = y.inner_listener.AcceptConn()
+		if err != nil {
+			return
+		}
+		ok := false
+		if release, ok = y.limiter.acquire(conn.Conn.RemoteAddr()); ok {
+			break
+		}
+		static.LoggerOrNop(y.logger).Warn(
+			"Too many pending handshakes, connection is dropped",
+			"uri", static.RedactedURI(y.uri),
+			"address", conn.Conn.RemoteAddr().String(),
+		)
+		conn.Conn.Close()
+	}
+	state := newConnState(
+		y.uri,
+		nil,
+		static.DIRECTION_INBOUND,
+		conn.SecurityLevel,
+		y.observer,
+		y.logger,
+	)
+	state.handshakeDone = release
+	// Must be reported before handshake goroutine can report anything
+	if y.observer != nil {
+		y.observer.OnAccept(state.snapshot())
+	}
+	yggr := newYggConn(
+		conn.Conn,
+		conn.Pkey,
+		y.allowList,
+		y.dm,
+		y.handshake,
+		state,
+	)
+	if y.registry != nil && !y.registry.add(yggr) {
+		yggr.Close()
+		err = static.ManagerClosedError{}
 		return
 	}
-	yggr := ConnToYggConn(conn.Conn, conn.Pkey, y.allowList, conn.SecurityLevel, y.dm)
 	ygg = *yggr
 	return
 }
 
+// Sets protocol versions accepted from connecting nodes.
+// If connections send own handshake pkg,
+// they reply with the same version as connecting node uses.
+//
+// If versions is empty, all SupportedProtoVersions are accepted.
+// It should be called before accepting any connection.
+func (y *YggListener) SetProtoVersions(versions []static.ProtoVersion) {
+	handshake := HandshakeConfig{}
+	if y.handshake != nil {
+		handshake = *y.handshake
+	}
+	handshake.Versions = versions
+	y.handshake = &handshake
+}
+
+// Sets max time to wait for handshake pkg of connecting nodes.
+// Zero means DEFAULT_HANDSHAKE_TIMEOUT.
+//
+// It should be called before accepting any connection.
+func (y *YggListener) SetHandshakeTimeout(timeout time.Duration) {
+	handshake := HandshakeConfig{}
+	if y.handshake != nil {
+		handshake = *y.handshake
+	}
+	handshake.Timeout = timeout
+	y.handshake = &handshake
+}
+
+// Sets limits of connections waiting for handshake pkg
+// in total and from each source ip.
+// Accepted connections exceeding limits are closed immediately.
+// Zero means no limit.
+//
+// Only ygg handshake pkg is awaited under limits.
+// Transport handshakes (tls, quic, wss) are completed
+// before connection is accepted from transport listener,
+// they are limited by transport timeouts instead.
+//
+// It should be called before accepting any connection.
+// It resets statistics returned by PendingHandshakes.
+func (y *YggListener) SetPendingHandshakesLimit(total, perIp int) {
+	if total <= 0 && perIp <= 0 {
+		y.limiter = nil
+		return
+	}
+	y.limiter = newHandshakeLimiter(total, perIp)
+}
+
+// Sets public keys of nodes allowed to connect to this listener.
+// Nil allows any node.
+//
+// It should be called before accepting any connection.
+func (y *YggListener) SetAllowList(allowList *static.AllowList) {
+	y.allowList = allowList
+}
+
+// Returns count of connections waiting for handshake pkg
+// and counts of dropped ones.
+func (y *YggListener) PendingHandshakes() PendingHandshakesStats {
+	return y.limiter.getStats()
+}
+
 // Close closes the listener.
 // Any blocked Accept operations will be unblocked and return errors.
 func (y *YggListener) Close() error {
+	if y.registry != nil {
+		y.registry.removeListener(y.listenerId)
+	}
 	return y.inner_listener.Close()
 }
 
@@ -18,18 +18,19 @@ package ytl
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
 	"encoding/hex"
 	"github.com/Yggdrasil-Unofficial/ytl/debugstuff"
 	"github.com/Yggdrasil-Unofficial/ytl/static"
+	"go.uber.org/goleak"
 	"io"
-	"os"
 	"net"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
-	"context"
-	"go.uber.org/goleak"
 )
 
 func TestParceMetaPackageWrongProto(t *testing.T) {
@@ -38,7 +39,7 @@ func TestParceMetaPackageWrongProto(t *testing.T) {
 		a.Write([]byte{'a', 't', 'a', 'm', 0, 4})
 		a.Write(make(ed25519.PublicKey, ed25519.PublicKeySize))
 	}()
-	err, _, _, _ := internalParseMetaPackage(b)
+	err, _, _, _ := internalParseMetaPackage(b, nil)
 	if err == nil {
 		t.Fatalf("Must raise UnknownProtoError")
 	}
@@ -58,6 +59,7 @@ func TestParceMetaPackage(t *testing.T) {
 	}
 	v := static.PROTO_VERSION()
 	v2 := static.ProtoVersion{Major: 1, Minor: 5}
+	v3 := static.ProtoVersion{Major: 0, Minor: 3}
 	cases := []CaseTestParceMetaPackage{
 		{
 			debugstuff.MockConn(),
@@ -76,6 +78,16 @@ func TestParceMetaPackage(t *testing.T) {
 			nil,
 			debugstuff.MockConnWrongVerContent()[:38],
 		},
+		{
+			debugstuff.MockOldVerConn(),
+			static.UnknownProtoVersionError{
+				Expected: static.PROTO_VERSION(),
+				Received: v3,
+			},
+			&v3,
+			nil,
+			debugstuff.MockConnOldVerContent()[:38],
+		},
 		{
 			debugstuff.MockTooShortConn(),
 			static.ConnTimeoutError{},
@@ -85,7 +97,7 @@ func TestParceMetaPackage(t *testing.T) {
 		},
 	}
 	for _, cse := range cases {
-		err, version, pkey, buf := parseMetaPackage(cse.conn, time.Minute/2)
+		err, version, pkey, buf := parseMetaPackage(cse.conn, time.Minute/2, nil)
 		if err != cse.err {
 			t.Fatalf("Wrong err %s %s", err, cse.err)
 		}
@@ -220,6 +232,26 @@ func yggConnTestCollision(
 	}
 }
 
+func TestYggConnDeduplicationRelease(t *testing.T) {
+	dm := NewDeduplicationManager(false, nil)
+	buf := make([]byte, len(debugstuff.MockConnContent())-1)
+	for i := 0; i < 2; i++ {
+		yggcon := ConnToYggConn(
+			debugstuff.MockConn(),
+			debugstuff.MockPubKey(),
+			nil,
+			0,
+			dm,
+		)
+		if _, err := io.ReadFull(yggcon, buf); err != nil {
+			t.Fatalf("Conn was closed: %s", err)
+		}
+		yggcon.Close()
+		// Closed connection removes itself from manager asynchronously
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 /*func TestYggConnCollisionII(t *testing.T){
 	// TODO Fix II Collision deduplication bug
 	// Second connection should be closed, but the first is closed
@@ -260,18 +292,16 @@ func TestYggConnWrite(t *testing.T) {
 	a.Close()
 	isClosed := make(chan bool, 1)
 	isClosed <- false
+	metaSent := make(chan struct{})
+	close(metaSent)
 	yc := YggConn{
-		a,
-		nil,
-		nil,
-		0,
-		make(chan []byte, 1),
-		nil,
-		nil,
-		func() {},
-		make(chan *static.ProtoVersion, 1),
-		make(chan ed25519.PublicKey, 1),
-		isClosed,
+		innerConn:        a,
+		extraReadBuffChn: make(chan []byte, 1),
+		closed:           make(chan struct{}),
+		pVersion:         make(chan *static.ProtoVersion, 1),
+		otherPublicKey:   make(chan ed25519.PublicKey, 1),
+		isClosed:         isClosed,
+		metaSent:         metaSent,
 	}
 	_, err := yc.Write([]byte{1, 2, 3})
 	if err == nil {
@@ -310,9 +340,9 @@ func TestConnToYggConnNilConn(t *testing.T) {
 
 func TestYggListenerOk(t *testing.T) {
 	uri, _ := url.Parse("a://b")
-	tr := debugstuff.MockTransport{"a", 0}
+	tr := debugstuff.MockTransport{Scheme: "a", SecureLvl: 0}
 	ls, _ := tr.Listen(nil, *uri, nil)
-	listener := YggListener{ls, nil, nil}
+	listener := YggListener{inner_listener: ls}
 	_, err := listener.Accept()
 	if err != nil {
 		t.Fatalf("Unecpected error: %s", err)
@@ -325,9 +355,9 @@ func TestYggListenerOk(t *testing.T) {
 
 func TestYggListenerErr(t *testing.T) {
 	uri, _ := url.Parse("a://b?error=true")
-	tr := debugstuff.MockTransport{"a", 0}
+	tr := debugstuff.MockTransport{Scheme: "a", SecureLvl: 0}
 	ls, _ := tr.Listen(nil, *uri, nil)
-	listener := YggListener{ls, nil, nil}
+	listener := YggListener{inner_listener: ls}
 	_, err := listener.Accept()
 	if err == nil {
 		t.Fatalf("Must raise error")
@@ -336,16 +366,16 @@ func TestYggListenerErr(t *testing.T) {
 }
 
 func TestYggConnLeak(t *testing.T) {
-    if os.Getenv("LEAKSTESTS") == "TRUE" {
-        defer goleak.VerifyNone(t)
-    }
+	if os.Getenv("LEAKSTESTS") == "TRUE" {
+		defer goleak.VerifyNone(t)
+	}
 	count := 100000
 	if testing.Short() {
 		count = 100
 	}
 	ctx := context.Background()
 	uri, _ := url.Parse("a://b")
-	transport := debugstuff.MockTransport{"a", 0}
+	transport := debugstuff.MockTransport{Scheme: "a", SecureLvl: 0}
 	dm := NewDeduplicationManager(true, nil)
 	for i := 0; i < count; i++ {
 		conn, _ := transport.Connect(ctx, *uri, nil, nil)
@@ -357,16 +387,16 @@ func TestYggConnLeak(t *testing.T) {
 }
 
 func TestYggDisplacementConnLeak(t *testing.T) {
-    if os.Getenv("LEAKSTESTS") == "TRUE" {
-        defer goleak.VerifyNone(t)
-    }
+	if os.Getenv("LEAKSTESTS") == "TRUE" {
+		defer goleak.VerifyNone(t)
+	}
 	count := 100000
 	if testing.Short() {
 		count = 100
 	}
 	ctx := context.Background()
 	uri, _ := url.Parse("a://b")
-	transport := debugstuff.MockTransport{"a", 0}
+	transport := debugstuff.MockTransport{Scheme: "a", SecureLvl: 0}
 	dm := NewDeduplicationManager(true, nil)
 	var conn *static.ConnResult = nil
 	var yggcon *YggConn = nil
@@ -387,3 +417,60 @@ func TestYggDisplacementConnLeak(t *testing.T) {
 		yggcon.Write([]byte{})
 	}
 }
+
+// Non ip addresses must not break ygg over ygg routing check
+func TestYggConnUnixAddr(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "ygg.sock"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c.Write(debugstuff.MockConnContent())
+		c.Close()
+	}()
+	a, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	yggcon := ConnToYggConn(a, nil, nil, 0, nil)
+	defer yggcon.Close()
+	key, err := yggcon.GetPublicKey()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if bytes.Compare(key, debugstuff.MockPubKey()) != 0 {
+		t.Fatalf("Invalid key")
+	}
+}
+
+func TestYggConnHandshake(t *testing.T) {
+	apub, apriv, _ := ed25519.GenerateKey(nil)
+	bpub, bpriv, _ := ed25519.GenerateKey(nil)
+	a, b := net.Pipe()
+	yggconA := ConnToYggConnWithHandshake(a, nil, nil, 0, nil, &HandshakeConfig{Key: apriv})
+	yggconB := ConnToYggConnWithHandshake(b, nil, nil, 0, nil, &HandshakeConfig{Key: bpriv})
+	defer yggconA.Close()
+	defer yggconB.Close()
+	go yggconA.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(yggconB, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Wrong data received: %s %s", buf, err)
+	}
+	for _, c := range []struct {
+		conn *YggConn
+		key  ed25519.PublicKey
+	}{{yggconA, bpub}, {yggconB, apub}} {
+		key, err := c.conn.GetPublicKey()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if bytes.Compare(key, c.key) != 0 {
+			t.Fatalf("Invalid key %s", hex.EncodeToString(key))
+		}
+	}
+}